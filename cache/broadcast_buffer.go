@@ -0,0 +1,78 @@
+package cache
+
+import (
+	"io"
+	"sync"
+)
+
+// broadcastBuffer is a growable, append-only byte buffer that can be read by
+// multiple independent cursors while it is still being written to. A reader
+// that falls behind, or stops reading entirely (e.g. because its client
+// disconnected), never blocks the writer, so the writer is always able to
+// finish draining its source.
+type broadcastBuffer struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	data   []byte
+	closed bool
+	err    error
+}
+
+func newBroadcastBuffer() *broadcastBuffer {
+	b := &broadcastBuffer{}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+// Write appends p to the buffer and wakes any readers waiting for more data.
+// It never blocks and never returns an error.
+func (b *broadcastBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	b.data = append(b.data, p...)
+	b.cond.Broadcast()
+	b.mu.Unlock()
+	return len(p), nil
+}
+
+// Close marks the buffer as complete. err, if non-nil, is surfaced to
+// readers once they catch up to the end of the buffer.
+func (b *broadcastBuffer) Close(err error) {
+	b.mu.Lock()
+	b.closed = true
+	b.err = err
+	b.cond.Broadcast()
+	b.mu.Unlock()
+}
+
+// NewReader returns an independent reader over the buffer, starting from the
+// beginning. Read blocks until more data has been written or the buffer is
+// closed.
+func (b *broadcastBuffer) NewReader() io.Reader {
+	return &broadcastBufferReader{buf: b}
+}
+
+type broadcastBufferReader struct {
+	buf *broadcastBuffer
+	pos int
+}
+
+func (r *broadcastBufferReader) Read(p []byte) (int, error) {
+	b := r.buf
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for r.pos >= len(b.data) && !b.closed {
+		b.cond.Wait()
+	}
+
+	if r.pos < len(b.data) {
+		n := copy(p, b.data[r.pos:])
+		r.pos += n
+		return n, nil
+	}
+
+	if b.err != nil {
+		return 0, b.err
+	}
+	return 0, io.EOF
+}
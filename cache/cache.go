@@ -18,9 +18,50 @@ type Cache[ObjectData any] interface {
 	// Cache stores an entry in the cache with the specified input key.
 	Cache(key CacheKey, data io.Reader, expires time.Time, objectData ObjectData) (*Entry[ObjectData], error)
 
+	// CacheStream behaves like Cache, but returns a reader that yields bytes
+	// as they are written to the backing store, instead of only after the
+	// whole of data has been consumed. This lets a caller start forwarding
+	// the response to its own client before the upstream body has finished
+	// arriving. The entry is committed to the cache when the returned
+	// stream is closed; Close blocks until that commit has happened, even
+	// if the caller stopped reading from the stream early.
+	CacheStream(key CacheKey, data io.Reader, expires time.Time, objectData ObjectData) (CacheStream[ObjectData], error)
+
 	// Delete removes an entry from the cache by its input key.
 	Delete(key CacheKey) error
 
 	// UpdateMetadata modifies the metadata of an entry in the cache.
 	UpdateMetadata(key CacheKey, modifier func(*EntryMetadata[ObjectData])) error
 }
+
+// CacheStream is returned by Cache.CacheStream. See that method for details.
+type CacheStream[ObjectData any] interface {
+	io.ReadCloser
+}
+
+// Entry is a cached object as returned by Cache.Get, Cache.Cache and the
+// stream returned by Cache.CacheStream once it has been committed.
+type Entry[ObjectData any] struct {
+	// Data is the entry's cached body. The caller is responsible for
+	// closing it.
+	Data io.ReadCloser
+
+	// Metadata is the entry's associated metadata.
+	Metadata EntryMetadata[ObjectData]
+
+	// Stale reports whether Metadata.Expires has already passed. FileCache
+	// still returns a stale entry rather than treating it as a miss, so
+	// callers can revalidate it against upstream with conditional headers
+	// instead of discarding it outright. RedisCache sets each entry's TTL to
+	// run out at Metadata.Expires, so a stale entry is gone from Redis and
+	// Get reports ErrCacheEntryNotFound instead.
+	Stale bool
+}
+
+// EntryMetadata holds everything about a cached entry other than its body:
+// when it expires, and caller-supplied ObjectData describing it (e.g.
+// response headers, ETag).
+type EntryMetadata[ObjectData any] struct {
+	Expires time.Time
+	Object  ObjectData
+}
@@ -0,0 +1,20 @@
+package cache
+
+import (
+	"apt_cacher_go/config"
+	"fmt"
+)
+
+// New builds the Cache[ObjectData] backend selected by cfg.Backend.
+//
+// cacheDir is only used by the "file" backend; it is ignored otherwise.
+func New[ObjectData any](cfg *config.CacheConfig, cacheDir string) (Cache[ObjectData], error) {
+	switch cfg.Backend {
+	case "", "file":
+		return NewFileCache[ObjectData](cacheDir), nil
+	case "redis":
+		return NewRedisCache[ObjectData](cfg)
+	default:
+		return nil, fmt.Errorf("unknown cache backend %q", cfg.Backend)
+	}
+}
@@ -0,0 +1,246 @@
+package cache
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FileCache is a Cache[ObjectData] backend that stores entries as files on
+// local disk. It is the default backend: simple, persistent across
+// restarts, and requires no external service to run.
+//
+// Each entry is stored as two files under dir, named after a hash of the
+// entry's key rather than the key itself, so that a CacheKey derived from
+// arbitrary request data (e.g. a URL) can never escape dir via path
+// traversal: "<hash>.body", holding the raw response bytes, and
+// "<hash>.meta", holding a gob-encoded EntryMetadata[ObjectData]. Both
+// files are written to a temporary name first and renamed into place, so a
+// reader never observes a partially written entry.
+type FileCache[ObjectData any] struct {
+	dir string
+}
+
+// NewFileCache returns a Cache[ObjectData] that stores entries under dir.
+// dir is created on first use if it does not already exist.
+func NewFileCache[ObjectData any](dir string) *FileCache[ObjectData] {
+	return &FileCache[ObjectData]{dir: dir}
+}
+
+func (c *FileCache[ObjectData]) keyName(key CacheKey) string {
+	return key.Hash()
+}
+
+func (c *FileCache[ObjectData]) bodyPath(key CacheKey) string {
+	return filepath.Join(c.dir, c.keyName(key)+".body")
+}
+
+func (c *FileCache[ObjectData]) metaPath(key CacheKey) string {
+	return filepath.Join(c.dir, c.keyName(key)+".meta")
+}
+
+func (c *FileCache[ObjectData]) readMetadata(key CacheKey) (*EntryMetadata[ObjectData], error) {
+	f, err := os.Open(c.metaPath(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrCacheEntryNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("error opening metadata for key %v: %w", key, err)
+	}
+	defer f.Close()
+
+	var meta EntryMetadata[ObjectData]
+	if err := gob.NewDecoder(f).Decode(&meta); err != nil {
+		return nil, fmt.Errorf("error decoding metadata for key %v: %w", key, err)
+	}
+
+	return &meta, nil
+}
+
+func (c *FileCache[ObjectData]) writeMetadata(key CacheKey, meta *EntryMetadata[ObjectData]) error {
+	tmp, err := os.CreateTemp(c.dir, "meta-*.tmp")
+	if err != nil {
+		return fmt.Errorf("error creating metadata file for key %v: %w", key, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := gob.NewEncoder(tmp).Encode(meta); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error encoding metadata for key %v: %w", key, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error writing metadata for key %v: %w", key, err)
+	}
+
+	return os.Rename(tmp.Name(), c.metaPath(key))
+}
+
+// Get retrieves an entry from the cache by its input key.
+func (c *FileCache[ObjectData]) Get(key CacheKey) (*Entry[ObjectData], error) {
+	meta, err := c.readMetadata(key)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(c.bodyPath(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrCacheEntryNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("error opening body for key %v: %w", key, err)
+	}
+
+	return &Entry[ObjectData]{
+		Data:     f,
+		Metadata: *meta,
+		Stale:    time.Now().After(meta.Expires),
+	}, nil
+}
+
+// Cache stores an entry in the cache with the specified input key.
+func (c *FileCache[ObjectData]) Cache(key CacheKey, data io.Reader, expires time.Time, objectData ObjectData) (*Entry[ObjectData], error) {
+	if err := os.MkdirAll(c.dir, 0700); err != nil {
+		return nil, fmt.Errorf("error creating cache dir %s: %w", c.dir, err)
+	}
+
+	tmp, err := os.CreateTemp(c.dir, "body-*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("error creating body file for key %v: %w", key, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, data); err != nil {
+		tmp.Close()
+		return nil, fmt.Errorf("error writing body for key %v: %w", key, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, fmt.Errorf("error writing body for key %v: %w", key, err)
+	}
+	if err := os.Rename(tmp.Name(), c.bodyPath(key)); err != nil {
+		return nil, fmt.Errorf("error committing body for key %v: %w", key, err)
+	}
+
+	meta := &EntryMetadata[ObjectData]{Expires: expires, Object: objectData}
+	if err := c.writeMetadata(key, meta); err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(c.bodyPath(key))
+	if err != nil {
+		return nil, fmt.Errorf("error reopening body for key %v: %w", key, err)
+	}
+
+	return &Entry[ObjectData]{Data: f, Metadata: *meta, Stale: false}, nil
+}
+
+// fileCacheStream is the CacheStream[ObjectData] returned by
+// FileCache.CacheStream. It tees the upstream data directly into a
+// temporary file on disk as it arrives, via a broadcastBuffer that lets
+// Read reflect bytes as they come in, while a background goroutine always
+// drains the source to completion, independent of how much the caller
+// itself reads. Close waits for that drain to finish and then commits the
+// temporary file into the cache.
+type fileCacheStream[ObjectData any] struct {
+	cache   *FileCache[ObjectData]
+	key     CacheKey
+	expires time.Time
+	meta    ObjectData
+
+	tmp    *os.File
+	reader io.Reader
+	done   chan error
+}
+
+// CacheStream behaves like Cache, but returns a reader that yields bytes as
+// they are read from data, rather than only once data is fully consumed.
+func (c *FileCache[ObjectData]) CacheStream(key CacheKey, data io.Reader, expires time.Time, objectData ObjectData) (CacheStream[ObjectData], error) {
+	if err := os.MkdirAll(c.dir, 0700); err != nil {
+		return nil, fmt.Errorf("error creating cache dir %s: %w", c.dir, err)
+	}
+
+	tmp, err := os.CreateTemp(c.dir, "body-*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("error creating body file for key %v: %w", key, err)
+	}
+
+	buf := newBroadcastBuffer()
+	done := make(chan error, 1)
+
+	go func() {
+		// Tee upstream straight to the temp file on disk: only the
+		// live-reader side needs the in-memory broadcastBuffer, since the
+		// file itself is what eventually gets committed to the cache.
+		_, err := io.Copy(io.MultiWriter(tmp, buf), data)
+		buf.Close(err)
+		done <- err
+	}()
+
+	return &fileCacheStream[ObjectData]{
+		cache:   c,
+		key:     key,
+		expires: expires,
+		meta:    objectData,
+		tmp:     tmp,
+		reader:  buf.NewReader(),
+		done:    done,
+	}, nil
+}
+
+func (s *fileCacheStream[ObjectData]) Read(p []byte) (int, error) {
+	return s.reader.Read(p)
+}
+
+// Close waits for the body to have finished being written to the temp file
+// (which may already have happened by the time Close is called) and
+// commits whatever was written into the cache directory, even if upstream
+// reading failed partway through: a caller that tracks how much actually
+// arrived (see proxy's countingReader) can treat the committed entry as a
+// resumable partial download instead of losing the bytes it already has.
+// Close still returns the original read error, if any, so the caller knows
+// the entry is incomplete.
+func (s *fileCacheStream[ObjectData]) Close() error {
+	copyErr := <-s.done
+
+	if err := s.tmp.Close(); err != nil {
+		os.Remove(s.tmp.Name())
+		return fmt.Errorf("error writing body for key %v: %w", s.key, err)
+	}
+	if err := os.Rename(s.tmp.Name(), s.cache.bodyPath(s.key)); err != nil {
+		return fmt.Errorf("error committing body for key %v: %w", s.key, err)
+	}
+
+	meta := &EntryMetadata[ObjectData]{Expires: s.expires, Object: s.meta}
+	if err := s.cache.writeMetadata(s.key, meta); err != nil {
+		return err
+	}
+
+	if copyErr != nil {
+		return fmt.Errorf("error streaming response body for key %v: %w", s.key, copyErr)
+	}
+	return nil
+}
+
+// Delete removes an entry from the cache by its input key.
+func (c *FileCache[ObjectData]) Delete(key CacheKey) error {
+	if err := os.Remove(c.bodyPath(key)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("error deleting body for key %v: %w", key, err)
+	}
+	if err := os.Remove(c.metaPath(key)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("error deleting metadata for key %v: %w", key, err)
+	}
+	return nil
+}
+
+// UpdateMetadata modifies the metadata of an entry in the cache.
+func (c *FileCache[ObjectData]) UpdateMetadata(key CacheKey, modifier func(*EntryMetadata[ObjectData])) error {
+	meta, err := c.readMetadata(key)
+	if err != nil {
+		return err
+	}
+
+	modifier(meta)
+
+	return c.writeMetadata(key, meta)
+}
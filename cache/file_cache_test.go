@@ -0,0 +1,47 @@
+package cache
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileCacheRoundTrip(t *testing.T) {
+	c := NewFileCache[string](t.TempDir())
+	key := CacheKey{Method: "GET", URL: "http://example.com/package.deb"}
+	expires := time.Now().Add(time.Hour)
+
+	if _, err := c.Cache(key, strings.NewReader("package contents"), expires, "etag-123"); err != nil {
+		t.Fatalf("Cache: %v", err)
+	}
+
+	entry, err := c.Get(key)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	defer entry.Data.Close()
+
+	body, err := io.ReadAll(entry.Data)
+	if err != nil {
+		t.Fatalf("reading entry body: %v", err)
+	}
+	if got := string(body); got != "package contents" {
+		t.Errorf("unexpected body: %q", got)
+	}
+	if entry.Metadata.Object != "etag-123" {
+		t.Errorf("unexpected metadata: %q", entry.Metadata.Object)
+	}
+	if entry.Stale {
+		t.Error("expected a freshly cached entry not to be stale")
+	}
+}
+
+func TestFileCacheGetMissingKeyReturnsErrCacheEntryNotFound(t *testing.T) {
+	c := NewFileCache[string](t.TempDir())
+
+	_, err := c.Get(CacheKey{Method: "GET", URL: "http://example.com/missing.deb"})
+	if err != ErrCacheEntryNotFound {
+		t.Errorf("Get on a missing key: got err %v, want ErrCacheEntryNotFound", err)
+	}
+}
@@ -0,0 +1,47 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+)
+
+// CacheKey identifies a cached entry. It is a small comparable value, not a
+// pointer, so it can be used directly as a map/singleflight key and copied
+// freely between callers.
+//
+// It deliberately carries no Vary-header component: the only header that
+// would realistically cause an apt repository to vary its response is
+// Accept-Encoding, and removeUnsupportedHeaders strips that from every
+// upstream request before it leaves the proxy, so upstream never returns
+// more than one representation for a given method+URL in the first place.
+type CacheKey struct {
+	Method string
+	URL    string
+}
+
+// MakeFromRequest derives the CacheKey for req: its method and the string
+// form of its URL, which is enough to identify a cacheable GET/HEAD request
+// to an apt repository.
+func MakeFromRequest(req *http.Request) CacheKey {
+	return CacheKey{Method: req.Method, URL: req.URL.String()}
+}
+
+// String renders the key the way it appears in log messages: "<method>
+// <url>". Backends that need key material safe to use as a filename or a
+// flat Redis key should use Hash instead, not this.
+func (k CacheKey) String() string {
+	return fmt.Sprintf("%s %s", k.Method, k.URL)
+}
+
+// Hash returns a hex-encoded sha256 digest of the key, suitable for use as
+// an on-disk filename or a Redis key: unlike String, it never contains
+// characters a backend would need to escape, and it's a fixed length
+// regardless of how long the URL is. Every Cache backend in this package
+// hashes a key the same way, so the same CacheKey always resolves to the
+// same stored entry no matter which backend is configured.
+func (k CacheKey) Hash() string {
+	sum := sha256.Sum256([]byte(k.String()))
+	return hex.EncodeToString(sum[:])
+}
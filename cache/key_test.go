@@ -0,0 +1,23 @@
+package cache
+
+import "testing"
+
+func TestCacheKeyHashIsStableAndDistinguishesKeys(t *testing.T) {
+	a := CacheKey{Method: "GET", URL: "http://example.com/package.deb"}
+	b := CacheKey{Method: "GET", URL: "http://example.com/package.deb"}
+	c := CacheKey{Method: "GET", URL: "http://example.com/other.deb"}
+	d := CacheKey{Method: "HEAD", URL: "http://example.com/package.deb"}
+
+	if a.Hash() != b.Hash() {
+		t.Error("identical keys produced different hashes")
+	}
+	if a.Hash() == c.Hash() {
+		t.Error("different URLs produced the same hash")
+	}
+	if a.Hash() == d.Hash() {
+		t.Error("different methods produced the same hash")
+	}
+	if len(a.Hash()) != 64 {
+		t.Errorf("expected a hex-encoded sha256 digest (64 chars), got %d", len(a.Hash()))
+	}
+}
@@ -0,0 +1,285 @@
+package cache
+
+import (
+	"apt_cacher_go/config"
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisCache is a Cache[ObjectData] backend that stores entries in a Redis
+// server instead of on local disk, so multiple proxy instances behind a
+// load balancer can share a warm cache and coordinate TTLs.
+//
+// Each entry is stored under two keys: "<prefix><hash>:body", holding the
+// raw response bytes, and "<prefix><hash>:meta", holding a gob-encoded
+// EntryMetadata[ObjectData]. hash is CacheKey.Hash(), the same sha256 digest
+// FileCache hashes its filenames from, so a given CacheKey resolves to the
+// same entry regardless of which backend is configured. Both keys share the
+// same TTL, set via EXPIRE, so a stale entry simply falls out of Redis on
+// its own.
+type RedisCache[ObjectData any] struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisCache connects to the Redis server described by cfg and returns a
+// Cache[ObjectData] backed by it.
+func NewRedisCache[ObjectData any](cfg *config.CacheConfig) (*RedisCache[ObjectData], error) {
+	client := redis.NewClient(&redis.Options{
+		Addr:     cfg.RedisAddr,
+		Password: cfg.RedisPassword,
+		DB:       cfg.RedisDB,
+	})
+
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		return nil, fmt.Errorf("error connecting to redis at %v: %w", cfg.RedisAddr, err)
+	}
+
+	return &RedisCache[ObjectData]{
+		client: client,
+		prefix: cfg.KeyPrefix,
+	}, nil
+}
+
+func (c *RedisCache[ObjectData]) bodyKey(key CacheKey) string {
+	return fmt.Sprintf("%s%s:body", c.prefix, key.Hash())
+}
+
+func (c *RedisCache[ObjectData]) metaKey(key CacheKey) string {
+	return fmt.Sprintf("%s%s:meta", c.prefix, key.Hash())
+}
+
+func (c *RedisCache[ObjectData]) readMetadata(ctx context.Context, key CacheKey) (*EntryMetadata[ObjectData], error) {
+	raw, err := c.client.Get(ctx, c.metaKey(key)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrCacheEntryNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("error reading metadata for key %v: %w", key, err)
+	}
+
+	var meta EntryMetadata[ObjectData]
+	if err := gob.NewDecoder(bytes.NewReader(raw)).Decode(&meta); err != nil {
+		return nil, fmt.Errorf("error decoding metadata for key %v: %w", key, err)
+	}
+
+	return &meta, nil
+}
+
+func (c *RedisCache[ObjectData]) writeMetadata(ctx context.Context, key CacheKey, meta *EntryMetadata[ObjectData], ttl time.Duration) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(meta); err != nil {
+		return fmt.Errorf("error encoding metadata for key %v: %w", key, err)
+	}
+
+	if err := c.client.Set(ctx, c.metaKey(key), buf.Bytes(), ttl).Err(); err != nil {
+		return fmt.Errorf("error writing metadata for key %v: %w", key, err)
+	}
+
+	return nil
+}
+
+// Get retrieves an entry from the cache by its input key.
+func (c *RedisCache[ObjectData]) Get(key CacheKey) (*Entry[ObjectData], error) {
+	ctx := context.Background()
+
+	meta, err := c.readMetadata(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := c.client.Get(ctx, c.bodyKey(key)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrCacheEntryNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("error reading body for key %v: %w", key, err)
+	}
+
+	return &Entry[ObjectData]{
+		Data:     io.NopCloser(bytes.NewReader(body)),
+		Metadata: *meta,
+		Stale:    time.Now().After(meta.Expires),
+	}, nil
+}
+
+// Cache stores an entry in the cache with the specified input key.
+func (c *RedisCache[ObjectData]) Cache(key CacheKey, data io.Reader, expires time.Time, objectData ObjectData) (*Entry[ObjectData], error) {
+	body, err := io.ReadAll(data)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response body for key %v: %w", key, err)
+	}
+
+	ctx := context.Background()
+
+	meta := &EntryMetadata[ObjectData]{
+		Expires: expires,
+		Object:  objectData,
+	}
+
+	ttl := time.Until(expires)
+	if ttl <= 0 {
+		ttl = time.Second // Round-trip through redis anyway, but expire it almost immediately.
+	}
+
+	if err := c.client.Set(ctx, c.bodyKey(key), body, ttl).Err(); err != nil {
+		return nil, fmt.Errorf("error writing body for key %v: %w", key, err)
+	}
+	if err := c.writeMetadata(ctx, key, meta, ttl); err != nil {
+		return nil, err
+	}
+
+	return &Entry[ObjectData]{
+		Data:     io.NopCloser(bytes.NewReader(body)),
+		Metadata: *meta,
+		Stale:    false,
+	}, nil
+}
+
+// redisAppendWriter is an io.Writer that appends every Write to a single
+// redis string key, in whatever chunk sizes its caller uses. It is how
+// RedisCache.CacheStream gets the body into redis incrementally instead of
+// accumulating the whole thing in memory for one final Set.
+type redisAppendWriter struct {
+	ctx    context.Context
+	client *redis.Client
+	key    string
+}
+
+func (w *redisAppendWriter) Write(p []byte) (int, error) {
+	if err := w.client.Append(w.ctx, w.key, string(p)).Err(); err != nil {
+		return 0, fmt.Errorf("error appending body for key %v: %w", w.key, err)
+	}
+	return len(p), nil
+}
+
+// redisCacheStream is the CacheStream[ObjectData] returned by
+// RedisCache.CacheStream. It tees the upstream data into a broadcastBuffer
+// as it arrives (so Read reflects bytes as they come in) while a background
+// goroutine APPENDs the same bytes to the body key in redis in bounded
+// chunks, so a large object never needs to be held in memory twice before
+// the first byte reaches the backing store. Close waits for that drain to
+// finish and then sets the TTL on the now-complete body key and writes the
+// metadata key.
+type redisCacheStream[ObjectData any] struct {
+	cache   *RedisCache[ObjectData]
+	key     CacheKey
+	expires time.Time
+	meta    ObjectData
+
+	reader io.Reader
+	done   chan error
+}
+
+// CacheStream behaves like Cache, but returns a reader that yields bytes as
+// they are read from data, rather than only once data is fully consumed.
+func (c *RedisCache[ObjectData]) CacheStream(key CacheKey, data io.Reader, expires time.Time, objectData ObjectData) (CacheStream[ObjectData], error) {
+	ctx := context.Background()
+	bodyKey := c.bodyKey(key)
+
+	// Clear out any previous value for this key first: APPEND would
+	// otherwise tack the new body onto whatever is already there.
+	if err := c.client.Del(ctx, bodyKey).Err(); err != nil {
+		return nil, fmt.Errorf("error clearing previous body for key %v: %w", key, err)
+	}
+
+	buf := newBroadcastBuffer()
+	done := make(chan error, 1)
+
+	go func() {
+		// Drain the full upstream body into buf and redis regardless of
+		// whether the caller keeps reading from the returned stream, so a
+		// client disconnecting mid-download doesn't prevent the entry
+		// from being cached for the next request.
+		w := &redisAppendWriter{ctx: ctx, client: c.client, key: bodyKey}
+		_, err := io.Copy(io.MultiWriter(buf, w), data)
+		buf.Close(err)
+		done <- err
+	}()
+
+	return &redisCacheStream[ObjectData]{
+		cache:   c,
+		key:     key,
+		expires: expires,
+		meta:    objectData,
+		reader:  buf.NewReader(),
+		done:    done,
+	}, nil
+}
+
+func (s *redisCacheStream[ObjectData]) Read(p []byte) (int, error) {
+	return s.reader.Read(p)
+}
+
+// Close waits for the body to have finished being appended to redis (which
+// may already have happened by the time Close is called), sets its TTL,
+// and writes the metadata key, even if upstream reading failed partway
+// through: a caller that tracks how much actually arrived (see proxy's
+// countingReader) can treat the committed entry as a resumable partial
+// download instead of losing the bytes it already has. Close still
+// returns the original read error, if any, so the caller knows the entry
+// is incomplete.
+func (s *redisCacheStream[ObjectData]) Close() error {
+	copyErr := <-s.done
+
+	ctx := context.Background()
+
+	ttl := time.Until(s.expires)
+	if ttl <= 0 {
+		ttl = time.Second // Round-trip through redis anyway, but expire it almost immediately.
+	}
+
+	if err := s.cache.client.Expire(ctx, s.cache.bodyKey(s.key), ttl).Err(); err != nil {
+		return fmt.Errorf("error setting TTL for key %v: %w", s.key, err)
+	}
+
+	meta := &EntryMetadata[ObjectData]{Expires: s.expires, Object: s.meta}
+	if err := s.cache.writeMetadata(ctx, s.key, meta, ttl); err != nil {
+		return err
+	}
+
+	if copyErr != nil {
+		return fmt.Errorf("error streaming response body for key %v: %w", s.key, copyErr)
+	}
+	return nil
+}
+
+// Delete removes an entry from the cache by its input key.
+func (c *RedisCache[ObjectData]) Delete(key CacheKey) error {
+	ctx := context.Background()
+	if err := c.client.Del(ctx, c.bodyKey(key), c.metaKey(key)).Err(); err != nil {
+		return fmt.Errorf("error deleting cache entry for key %v: %w", key, err)
+	}
+	return nil
+}
+
+// UpdateMetadata modifies the metadata of an entry in the cache.
+func (c *RedisCache[ObjectData]) UpdateMetadata(key CacheKey, modifier func(*EntryMetadata[ObjectData])) error {
+	ctx := context.Background()
+
+	meta, err := c.readMetadata(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	modifier(meta)
+
+	ttl := time.Until(meta.Expires)
+	if ttl <= 0 {
+		ttl = time.Second
+	}
+
+	// The body key carries its own independent TTL from when it was
+	// written by Cache; push it forward too, or it can expire out from
+	// under a metadata key that now claims the entry is still fresh.
+	if err := c.client.Expire(ctx, c.bodyKey(key), ttl).Err(); err != nil {
+		return fmt.Errorf("error refreshing body TTL for key %v: %w", key, err)
+	}
+
+	return c.writeMetadata(ctx, key, meta, ttl)
+}
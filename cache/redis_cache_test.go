@@ -0,0 +1,27 @@
+package cache
+
+import "testing"
+
+// TestRedisCacheKeysAreHashedConsistentlyWithFileCache verifies that
+// RedisCache derives its Redis keys from CacheKey.Hash(), the same digest
+// FileCache hashes its filenames from, so a given CacheKey resolves to the
+// same entry no matter which backend is configured. This only exercises key
+// derivation, which needs no network access; CacheStream/UpdateMetadata/TTL
+// behavior against a real Redis server would need a harness like miniredis,
+// which isn't available in this environment.
+func TestRedisCacheKeysAreHashedConsistentlyWithFileCache(t *testing.T) {
+	key := CacheKey{Method: "GET", URL: "http://example.com/package.deb"}
+
+	rc := &RedisCache[string]{prefix: "apt:"}
+	fc := NewFileCache[string](t.TempDir())
+
+	wantBody := "apt:" + fc.keyName(key) + ":body"
+	wantMeta := "apt:" + fc.keyName(key) + ":meta"
+
+	if got := rc.bodyKey(key); got != wantBody {
+		t.Errorf("bodyKey = %q, want %q", got, wantBody)
+	}
+	if got := rc.metaKey(key); got != wantMeta {
+		t.Errorf("metaKey = %q, want %q", got, wantMeta)
+	}
+}
@@ -0,0 +1,101 @@
+package config
+
+import "time"
+
+// Config holds the proxy's runtime configuration, populated from the
+// command line and/or a config file at startup.
+type Config struct {
+	// AlwaysCache forces every eligible response to be cached regardless of
+	// the upstream's Cache-Control directives.
+	AlwaysCache bool
+
+	// Cache selects and configures the cache backend used to store
+	// proxied responses.
+	Cache CacheConfig
+
+	// Debug, if true, logs a dump of every request sent upstream and every
+	// response received from upstream; requests served from the cache are
+	// not dumped.
+	Debug bool
+
+	// RequestHeaderRewrites are applied to every request before it is sent
+	// upstream.
+	RequestHeaderRewrites []HeaderRewriteRule
+
+	// ResponseHeaderRewrites are applied to every response received from
+	// upstream, before it is cached or sent to the client.
+	ResponseHeaderRewrites []HeaderRewriteRule
+
+	// URLFilter restricts which request URLs the proxy will forward.
+	URLFilter URLFilterConfig
+
+	// Upstream tunes the per-host transport pool used for upstream
+	// requests. Zero-valued fields fall back to upstream.DefaultConfig().
+	Upstream UpstreamConfig
+}
+
+// UpstreamConfig tunes the per-host HTTP transport pool.
+type UpstreamConfig struct {
+	// MaxIdleConnsPerHost bounds how many idle keepalive connections are
+	// kept around per host.
+	MaxIdleConnsPerHost int
+
+	// IdleConnTimeout is how long an idle connection is kept before being
+	// closed.
+	IdleConnTimeout time.Duration
+
+	// ResponseHeaderTimeout bounds how long to wait for response headers
+	// after the request has been written.
+	ResponseHeaderTimeout time.Duration
+
+	// DialTimeout bounds how long establishing the underlying TCP
+	// connection may take.
+	DialTimeout time.Duration
+
+	// ProxyURL, if set, chains all outgoing requests through this upstream
+	// proxy (HTTP_PROXY-style).
+	ProxyURL string
+}
+
+// HeaderRewriteRule replaces, or with an empty Value deletes, a single
+// header.
+type HeaderRewriteRule struct {
+	Header string
+	Value  string
+}
+
+// URLFilterConfig configures an allow/deny list of URL patterns.
+type URLFilterConfig struct {
+	// Allow, if non-empty, is a list of regular expressions; a request URL
+	// must match at least one of them to be forwarded.
+	Allow []string
+
+	// Deny is a list of regular expressions; a request URL matching any of
+	// them is rejected. Checked after Allow.
+	Deny []string
+}
+
+// CacheConfig selects and configures the cache backend used by the proxy.
+type CacheConfig struct {
+	// Backend selects the cache implementation. Supported values are
+	// "file" (the default) and "redis".
+	Backend string
+
+	// RedisAddr is the "host:port" of the Redis server used by the "redis"
+	// backend.
+	RedisAddr string
+
+	// RedisPassword authenticates against the Redis server, if set.
+	RedisPassword string
+
+	// RedisDB selects the Redis logical database to use.
+	RedisDB int
+
+	// KeyPrefix is prepended to every key stored by the "redis" backend, so
+	// multiple proxy deployments can share a single Redis instance without
+	// colliding.
+	KeyPrefix string
+}
+
+// Global holds the active configuration, populated once at startup.
+var Global Config
@@ -0,0 +1,146 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// byteRange is an inclusive [Start, End] span of bytes within a response
+// body, as used by the Range/Content-Range HTTP headers. Its fields are
+// exported so that rangeSet, embedded in cachedRequestInfo, can be
+// gob-encoded by the Redis cache backend: gob refuses to encode a struct
+// with no exported fields, even for a nil slice of it.
+type byteRange struct {
+	Start int64
+	End   int64 // inclusive
+}
+
+func (r byteRange) length() int64 { return r.End - r.Start + 1 }
+
+// parseRangeHeader parses a single-range "Range: bytes=start-end" header
+// against a response of the given size. Multi-range requests
+// ("bytes=0-10,20-30") are rejected, since apt never issues them.
+func parseRangeHeader(header string, size int64) (byteRange, error) {
+	const prefix = "bytes="
+	if !strings.HasPrefix(header, prefix) {
+		return byteRange{}, fmt.Errorf("unsupported range unit in %q", header)
+	}
+	spec := strings.TrimPrefix(header, prefix)
+	if strings.Contains(spec, ",") {
+		return byteRange{}, fmt.Errorf("multi-range requests are not supported: %q", header)
+	}
+
+	parts := strings.SplitN(spec, "-", 2)
+	if len(parts) != 2 {
+		return byteRange{}, fmt.Errorf("malformed range %q", header)
+	}
+
+	var start, end int64
+	var err error
+
+	switch {
+	case parts[0] == "": // suffix range, "bytes=-N" means the last N bytes
+		n, perr := strconv.ParseInt(parts[1], 10, 64)
+		if perr != nil {
+			return byteRange{}, fmt.Errorf("malformed range %q: %w", header, perr)
+		}
+		start = size - n
+		if start < 0 {
+			start = 0
+		}
+		end = size - 1
+	case parts[1] == "": // open-ended range, "bytes=N-" means from N to the end
+		if start, err = strconv.ParseInt(parts[0], 10, 64); err != nil {
+			return byteRange{}, fmt.Errorf("malformed range %q: %w", header, err)
+		}
+		end = size - 1
+	default:
+		if start, err = strconv.ParseInt(parts[0], 10, 64); err != nil {
+			return byteRange{}, fmt.Errorf("malformed range %q: %w", header, err)
+		}
+		if end, err = strconv.ParseInt(parts[1], 10, 64); err != nil {
+			return byteRange{}, fmt.Errorf("malformed range %q: %w", header, err)
+		}
+	}
+
+	if size <= 0 || start < 0 || end >= size || start > end {
+		return byteRange{}, fmt.Errorf("range %q out of bounds for size %d", header, size)
+	}
+
+	return byteRange{Start: start, End: end}, nil
+}
+
+// sliceReader returns a reader over just the bytes in br. If data is an
+// io.ReadSeeker (as *os.File, returned by the file cache backend, is) it
+// seeks directly to br.Start; otherwise it discards the bytes before
+// br.Start by reading (and dropping) them.
+func sliceReader(data io.Reader, br byteRange) (io.Reader, error) {
+	if seeker, ok := data.(io.ReadSeeker); ok {
+		if _, err := seeker.Seek(br.Start, io.SeekStart); err != nil {
+			return nil, err
+		}
+		return io.LimitReader(seeker, br.length()), nil
+	}
+
+	if _, err := io.CopyN(io.Discard, data, br.Start); err != nil {
+		return nil, err
+	}
+	return io.LimitReader(data, br.length()), nil
+}
+
+// rangeSet tracks the union of byte ranges already covered by a cache
+// entry. In practice it only ever holds at most one range, starting at 0:
+// the cache backends always write a new entry's body sequentially from the
+// start, whether that write runs to completion or is cut short by an
+// upstream error. So a rangeSet either reads as empty (nothing cached yet),
+// a prefix [0, n) shorter than the entry's ContentLength (a download that
+// was interrupted partway through and can be resumed, see
+// CachingMitmProxy.completePartialEntry), or the full [0, size) span.
+//
+// This intentionally does not support caching arbitrary client-requested
+// ranges that don't start at 0: apt never issues those (see
+// parseRangeHeader's single-range restriction), and the proxy only ever
+// writes a cache entry's body as one sequential stream, so a general
+// interval-merging structure would add complexity with no real use case.
+type rangeSet []byteRange
+
+// covers reports whether r is fully covered by the ranges already in s.
+func (s rangeSet) covers(r byteRange) bool {
+	for _, have := range s {
+		if have.Start <= r.Start && have.End >= r.End {
+			return true
+		}
+	}
+	return false
+}
+
+// full reports whether s covers the entire [0, size) span.
+func (s rangeSet) full(size int64) bool {
+	if size <= 0 {
+		return false
+	}
+	return s.covers(byteRange{Start: 0, End: size - 1})
+}
+
+// coveredPrefix returns how many leading bytes, starting at offset 0, are
+// already covered by s, i.e. how far a sequential download represented by s
+// has gotten. It is 0 if s is empty or its first range doesn't start at 0.
+func (s rangeSet) coveredPrefix() int64 {
+	if len(s) == 0 || s[0].Start != 0 {
+		return 0
+	}
+	return s[0].End + 1
+}
+
+// prefixRangeSet returns a rangeSet covering the leading [0, n) span, as
+// produced by writing a cache entry's body sequentially from the start: n
+// is the full ContentLength for a completed write, or less than that for
+// one interrupted partway through.
+func prefixRangeSet(n int64) rangeSet {
+	if n <= 0 {
+		return nil
+	}
+	return rangeSet{{Start: 0, End: n - 1}}
+}
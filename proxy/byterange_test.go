@@ -0,0 +1,75 @@
+package proxy
+
+import "testing"
+
+func TestParseRangeHeader(t *testing.T) {
+	const size = 100
+
+	tests := []struct {
+		name    string
+		header  string
+		want    byteRange
+		wantErr bool
+	}{
+		{name: "start and end", header: "bytes=0-9", want: byteRange{Start: 0, End: 9}},
+		{name: "open-ended", header: "bytes=90-", want: byteRange{Start: 90, End: 99}},
+		{name: "suffix", header: "bytes=-10", want: byteRange{Start: 90, End: 99}},
+		{name: "suffix larger than size", header: "bytes=-1000", want: byteRange{Start: 0, End: 99}},
+		{name: "unsupported unit", header: "chunks=0-9", wantErr: true},
+		{name: "multi-range", header: "bytes=0-9,20-29", wantErr: true},
+		{name: "malformed", header: "bytes=abc", wantErr: true},
+		{name: "start past end", header: "bytes=50-10", wantErr: true},
+		{name: "end out of bounds", header: "bytes=0-999", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseRangeHeader(tt.header, size)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseRangeHeader(%q): expected error, got %+v", tt.header, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseRangeHeader(%q): unexpected error: %v", tt.header, err)
+			}
+			if got != tt.want {
+				t.Errorf("parseRangeHeader(%q) = %+v, want %+v", tt.header, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRangeSetCoversAndFull(t *testing.T) {
+	s := rangeSet{{Start: 0, End: 9}, {Start: 20, End: 29}}
+
+	if !s.covers(byteRange{Start: 2, End: 5}) {
+		t.Error("expected s to cover a range within its first entry")
+	}
+	if s.covers(byteRange{Start: 5, End: 25}) {
+		t.Error("expected s not to cover a range spanning a gap between entries")
+	}
+	if s.full(30) {
+		t.Error("expected s not to be full: it has a gap")
+	}
+
+	full := prefixRangeSet(30)
+	if !full.full(30) {
+		t.Error("expected prefixRangeSet(30) to be full for size 30")
+	}
+	if full.full(31) {
+		t.Error("expected prefixRangeSet(30) not to be full for a larger size")
+	}
+	if got := full.coveredPrefix(); got != 30 {
+		t.Errorf("expected prefixRangeSet(30).coveredPrefix() = 30, got %d", got)
+	}
+
+	partial := prefixRangeSet(12)
+	if partial.full(30) {
+		t.Error("expected prefixRangeSet(12) not to be full for size 30")
+	}
+	if got := partial.coveredPrefix(); got != 12 {
+		t.Errorf("expected prefixRangeSet(12).coveredPrefix() = 12, got %d", got)
+	}
+}
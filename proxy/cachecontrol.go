@@ -0,0 +1,119 @@
+package proxy
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cacheDirective captures the Cache-Control directives relevant to deciding
+// whether, and for how long, a response may be cached.
+type cacheDirective struct {
+	noStore bool
+	private bool
+
+	hasMaxAge bool
+	maxAge    time.Duration
+
+	expires time.Time
+
+	// conditionalHeaders are the conditional request headers present on
+	// the request this directive was parsed from, captured so they can be
+	// stripped before the request is forwarded upstream. See
+	// conditionalHeaders.removeFromHeader.
+	conditionalHeaders conditionalHeaders
+}
+
+// conditionalHeaders holds a client's own conditional request headers. They
+// are removed from the outgoing request: the proxy sets its own
+// If-None-Match/If-Modified-Since when revalidating a stale cache entry,
+// and a client-supplied one would otherwise race or conflict with that.
+type conditionalHeaders struct {
+	ifNoneMatch       string
+	ifModifiedSince   string
+	ifMatch           string
+	ifUnmodifiedSince string
+}
+
+// removeFromHeader deletes the conditional headers captured in c from
+// header.
+func (c conditionalHeaders) removeFromHeader(header http.Header) {
+	header.Del("If-None-Match")
+	header.Del("If-Modified-Since")
+	header.Del("If-Match")
+	header.Del("If-Unmodified-Since")
+}
+
+// parseCacheDirective parses the Cache-Control and Expires headers found in
+// header, as seen on either a request or a response.
+func parseCacheDirective(header http.Header) *cacheDirective {
+	d := &cacheDirective{
+		conditionalHeaders: conditionalHeaders{
+			ifNoneMatch:       header.Get("If-None-Match"),
+			ifModifiedSince:   header.Get("If-Modified-Since"),
+			ifMatch:           header.Get("If-Match"),
+			ifUnmodifiedSince: header.Get("If-Unmodified-Since"),
+		},
+	}
+
+	for _, part := range strings.Split(header.Get("Cache-Control"), ",") {
+		part = strings.TrimSpace(part)
+		name, value, _ := strings.Cut(part, "=")
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "no-store":
+			d.noStore = true
+		case "private":
+			d.private = true
+		case "max-age":
+			if n, err := strconv.Atoi(strings.TrimSpace(value)); err == nil {
+				d.hasMaxAge = true
+				d.maxAge = time.Duration(n) * time.Second
+			}
+		}
+	}
+
+	if t, err := http.ParseTime(header.Get("Expires")); err == nil {
+		d.expires = t
+	}
+
+	return d
+}
+
+// shouldCache reports whether a response carrying this directive may be
+// stored at all. "no-cache" is not parsed: a "no-cache" response is cached
+// like any other and gets the same lifetime (see getExpiresOrDefault), and
+// it is served without revalidation until that lifetime has passed.
+func (d *cacheDirective) shouldCache() bool {
+	return d != nil && !d.noStore && !d.private
+}
+
+// getExpiresOrDefault returns when a response carrying this directive
+// should be treated as stale: Cache-Control's max-age if present, else
+// Expires, else now plus defaultMaxAge.
+func (d *cacheDirective) getExpiresOrDefault(defaultMaxAge time.Duration) time.Time {
+	if d != nil {
+		if d.hasMaxAge {
+			return time.Now().Add(d.maxAge)
+		}
+		if !d.expires.IsZero() {
+			return d.expires
+		}
+	}
+	return time.Now().Add(defaultMaxAge)
+}
+
+// removeUnsupportedHeaders strips hop-by-hop and transfer-related headers
+// that the proxy can't honor correctly: Accept-Encoding is removed so
+// upstream always returns an identity-encoded body, since the proxy caches
+// and slices raw response bytes and can't do so safely for a compressed
+// one.
+func removeUnsupportedHeaders(header http.Header) {
+	header.Del("Accept-Encoding")
+	header.Del("Connection")
+	header.Del("Proxy-Connection")
+	header.Del("Keep-Alive")
+	header.Del("TE")
+	header.Del("Trailer")
+	header.Del("Upgrade")
+}
@@ -0,0 +1,207 @@
+// Package certs implements an on-the-fly certificate authority for MITM'ing
+// intercepted HTTPS connections: it lazily signs a leaf certificate for
+// each upstream host, reusing a single leaf key across every host so
+// repeated connections don't pay for a fresh key generation, and caches the
+// results both in memory and, optionally, on disk.
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// CertAuthority issues leaf TLS certificates for intercepted hosts, signed
+// by a root certificate trusted by the client's machine.
+type CertAuthority interface {
+	// GetCertForHost returns a leaf certificate valid for host, which may
+	// be a bare hostname/IP (as seen via SNI) or a "host:port" pair (as
+	// seen in a CONNECT request). A certificate is signed and cached
+	// lazily on first use, and re-signed once it is close to expiring.
+	GetCertForHost(host string) (*tls.Certificate, error)
+}
+
+// leafValidity is how long a freshly signed leaf certificate is valid for.
+const leafValidity = 365 * 24 * time.Hour
+
+// refreshBefore is how long before a cached leaf's NotAfter it is re-signed,
+// so a connection can never race a certificate going invalid mid-handshake.
+const refreshBefore = 30 * 24 * time.Hour
+
+// maxCachedLeafs bounds how many leaf certificates are kept in memory; the
+// least recently used one is evicted to make room for a new host.
+const maxCachedLeafs = 1024
+
+// Authority is a CertAuthority backed by a root CA certificate and key. It
+// generates a single ECDSA leaf key once, at construction, and reuses it
+// for every leaf it signs: leaves then only ever differ in their SAN and
+// validity, which keeps signing cheap and avoids invalidating a client's
+// certificate-pinning cache on every new host.
+type Authority struct {
+	rootCert *x509.Certificate
+	rootKey  any
+	leafKey  *ecdsa.PrivateKey
+
+	// cacheDir, if non-empty, persists signed leaf certificates to disk, so
+	// a leaf evicted from the in-memory LRU can be reloaded instead of
+	// re-signed. leafKey is not persisted, so leaves written by an earlier
+	// run don't match it and are re-signed after a restart.
+	cacheDir string
+
+	mu    sync.Mutex
+	leafs *leafLRU
+}
+
+// LoadAuthority creates an Authority from a PEM-encoded root certificate and
+// private key file, such as the ones a client's machine is configured to
+// trust. cacheDir, if non-empty, is used to persist signed leaf
+// certificates; it is created if it doesn't already exist. Leaves persisted
+// by an earlier run are not reused, since each run generates a new leaf key.
+func LoadAuthority(certFile, keyFile, cacheDir string) (*Authority, error) {
+	certPEM, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading CA certificate %q: %w", certFile, err)
+	}
+	keyPEM, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading CA key %q: %w", keyFile, err)
+	}
+
+	tlsCert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing CA key pair: %w", err)
+	}
+	rootCert, err := x509.ParseCertificate(tlsCert.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("error parsing CA certificate: %w", err)
+	}
+
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("error generating leaf key: %w", err)
+	}
+
+	if cacheDir != "" {
+		if err := os.MkdirAll(cacheDir, 0700); err != nil {
+			return nil, fmt.Errorf("error creating cert cache dir %q: %w", cacheDir, err)
+		}
+	}
+
+	return &Authority{
+		rootCert: rootCert,
+		rootKey:  tlsCert.PrivateKey,
+		leafKey:  leafKey,
+		cacheDir: cacheDir,
+		leafs:    newLeafLRU(maxCachedLeafs),
+	}, nil
+}
+
+// GetCertForHost implements CertAuthority.
+func (a *Authority) GetCertForHost(host string) (*tls.Certificate, error) {
+	name := hostname(host)
+
+	a.mu.Lock()
+	if cert, ok := a.leafs.get(name); ok && !needsRefresh(cert) {
+		a.mu.Unlock()
+		return cert, nil
+	}
+	a.mu.Unlock()
+
+	if a.cacheDir != "" {
+		if cert, ok := a.loadFromDisk(name); ok && !needsRefresh(cert) {
+			a.mu.Lock()
+			a.leafs.put(name, cert)
+			a.mu.Unlock()
+			return cert, nil
+		}
+	}
+
+	cert, err := a.signLeaf(name)
+	if err != nil {
+		return nil, fmt.Errorf("error signing certificate for %q: %w", name, err)
+	}
+
+	a.mu.Lock()
+	a.leafs.put(name, cert)
+	a.mu.Unlock()
+
+	if a.cacheDir != "" {
+		if err := a.saveToDisk(name, cert); err != nil {
+			// Not fatal: the cert is still usable, it just has to be
+			// re-signed if it is later evicted from memory.
+			fmt.Fprintf(os.Stderr, "warning: error persisting certificate for %q: %v\n", name, err)
+		}
+	}
+
+	return cert, nil
+}
+
+// needsRefresh reports whether cert is close enough to expiring that it
+// should be re-signed rather than reused.
+func needsRefresh(cert *tls.Certificate) bool {
+	leaf := cert.Leaf
+	if leaf == nil {
+		var err error
+		leaf, err = x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return true
+		}
+	}
+	return time.Now().After(leaf.NotAfter.Add(-refreshBefore))
+}
+
+// signLeaf signs a fresh leaf certificate for name, reusing a.leafKey.
+func (a *Authority) signLeaf(name string) (*tls.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("error generating serial number: %w", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour), // tolerate client/server clock skew
+		NotAfter:     time.Now().Add(leafValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if ip := net.ParseIP(name); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{name}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, a.rootCert, &a.leafKey.PublicKey, a.rootKey)
+	if err != nil {
+		return nil, fmt.Errorf("error creating certificate: %w", err)
+	}
+
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing freshly signed certificate: %w", err)
+	}
+
+	return &tls.Certificate{
+		Certificate: [][]byte{der, a.rootCert.Raw},
+		PrivateKey:  a.leafKey,
+		Leaf:        leaf,
+	}, nil
+}
+
+// hostname strips an optional ":port" suffix from host, as seen in a
+// CONNECT target, leaving the bare name a SAN entry should be issued for.
+func hostname(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
@@ -0,0 +1,79 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// diskPath returns the path a.cacheDir stores name's leaf certificate at.
+// The leaf key itself is never persisted: on every restart a fresh one is
+// generated, loadFromDisk rejects leaves signed for an earlier key, and
+// GetCertForHost re-signs (and re-persists) each one on first use.
+//
+// name ultimately comes from the client's TLS SNI, which a client fully
+// controls, so it is rejected outright rather than trusted as a path
+// component: a name containing a path separator (e.g. "../../etc/passwd")
+// could otherwise read or write a cache file outside cacheDir.
+func (a *Authority) diskPath(name string) (string, error) {
+	if name == "" || strings.ContainsAny(name, `/\`) {
+		return "", fmt.Errorf("invalid certificate cache name %q", name)
+	}
+	return filepath.Join(a.cacheDir, name+".pem"), nil
+}
+
+// loadFromDisk reads back a previously persisted leaf certificate for name,
+// re-attaching a.leafKey as its private key. It returns ok=false if no
+// cached certificate exists, its name is invalid, it can't be parsed, or
+// it was signed for a different leaf key than a.leafKey.
+func (a *Authority) loadFromDisk(name string) (cert *tls.Certificate, ok bool) {
+	path, err := a.diskPath(name)
+	if err != nil {
+		return nil, false
+	}
+
+	der, err := readPEMCertificate(path)
+	if err != nil {
+		return nil, false
+	}
+
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, false
+	}
+	if !a.leafKey.PublicKey.Equal(leaf.PublicKey) {
+		return nil, false
+	}
+
+	return &tls.Certificate{
+		Certificate: [][]byte{der, a.rootCert.Raw},
+		PrivateKey:  a.leafKey,
+		Leaf:        leaf,
+	}, true
+}
+
+// saveToDisk persists cert's leaf certificate (not its key) for name.
+func (a *Authority) saveToDisk(name string, cert *tls.Certificate) error {
+	path, err := a.diskPath(name)
+	if err != nil {
+		return err
+	}
+	block := &pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}
+	return os.WriteFile(path, pem.EncodeToMemory(block), 0600)
+}
+
+func readPEMCertificate(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("%s: not a PEM certificate", path)
+	}
+	return block.Bytes, nil
+}
@@ -0,0 +1,30 @@
+package certs
+
+import "testing"
+
+func TestDiskPathRejectsPathSeparators(t *testing.T) {
+	a := &Authority{cacheDir: "/tmp/certcache"}
+
+	tests := []struct {
+		name    string
+		sni     string
+		wantErr bool
+	}{
+		{name: "plain hostname", sni: "example.com", wantErr: false},
+		{name: "empty", sni: "", wantErr: true},
+		{name: "forward slash traversal", sni: "../../etc/passwd", wantErr: true},
+		{name: "backslash", sni: `..\..\etc\passwd`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := a.diskPath(tt.sni)
+			if tt.wantErr && err == nil {
+				t.Errorf("diskPath(%q): expected an error, got none", tt.sni)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("diskPath(%q): unexpected error: %v", tt.sni, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package certs
+
+import (
+	"container/list"
+	"crypto/tls"
+)
+
+// leafLRU is a fixed-capacity, least-recently-used cache of signed leaf
+// certificates keyed by hostname. It is not safe for concurrent use; callers
+// must hold their own lock (Authority does).
+type leafLRU struct {
+	capacity int
+	entries  map[string]*list.Element
+	order    *list.List // front = most recently used
+}
+
+type leafLRUEntry struct {
+	key  string
+	cert *tls.Certificate
+}
+
+func newLeafLRU(capacity int) *leafLRU {
+	return &leafLRU{
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+func (c *leafLRU) get(key string) (*tls.Certificate, bool) {
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return el.Value.(*leafLRUEntry).cert, true
+}
+
+func (c *leafLRU) put(key string, cert *tls.Certificate) {
+	if el, ok := c.entries[key]; ok {
+		el.Value.(*leafLRUEntry).cert = cert
+		c.order.MoveToFront(el)
+		return
+	}
+
+	el := c.order.PushFront(&leafLRUEntry{key: key, cert: cert})
+	c.entries[key] = el
+
+	if c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*leafLRUEntry).key)
+	}
+}
@@ -0,0 +1,52 @@
+package certs
+
+import (
+	"crypto/tls"
+	"testing"
+)
+
+func TestLeafLRUEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newLeafLRU(2)
+
+	certA := &tls.Certificate{}
+	certB := &tls.Certificate{}
+	certC := &tls.Certificate{}
+
+	c.put("a", certA)
+	c.put("b", certB)
+
+	// Touch "a" so "b" becomes the least recently used entry.
+	if _, ok := c.get("a"); !ok {
+		t.Fatal("expected to find a")
+	}
+
+	c.put("c", certC)
+
+	if _, ok := c.get("b"); ok {
+		t.Error("expected b to have been evicted")
+	}
+	if _, ok := c.get("a"); !ok {
+		t.Error("expected a to still be present")
+	}
+	if _, ok := c.get("c"); !ok {
+		t.Error("expected c to still be present")
+	}
+}
+
+func TestLeafLRUPutOverwritesExisting(t *testing.T) {
+	c := newLeafLRU(2)
+
+	certA1 := &tls.Certificate{}
+	certA2 := &tls.Certificate{}
+
+	c.put("a", certA1)
+	c.put("a", certA2)
+
+	got, ok := c.get("a")
+	if !ok {
+		t.Fatal("expected to find a")
+	}
+	if got != certA2 {
+		t.Error("expected put to overwrite the existing entry's certificate")
+	}
+}
@@ -0,0 +1,109 @@
+package interceptor
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ANSI color codes used to make dumped requests/responses easier to scan in
+// a terminal.
+const (
+	colorReset  = "\033[0m"
+	colorMethod = "\033[36m"
+	colorHeader = "\033[33m"
+	colorBody   = "\033[32m"
+)
+
+// maxDumpBodyPreview bounds how many response bytes AfterResponse peeks at
+// to print, so dumping e.g. a 200MB .deb download doesn't buffer the whole
+// thing in memory or flood the log.
+const maxDumpBodyPreview = 2048
+
+// DumpInterceptor logs a coloured dump of every request the proxy sends
+// upstream and every response it gets back (requests served from the cache
+// are not dumped), decoding application/x-www-form-urlencoded
+// POST bodies and previewing textual response bodies along the way, for
+// debugging TLS-intercepted traffic.
+type DumpInterceptor struct{}
+
+func (DumpInterceptor) BeforeRequest(req *http.Request) (*http.Response, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s>>> %s %s%s\n", colorMethod, req.Method, req.URL, colorReset)
+	writeHeader(&buf, req.Header)
+
+	if req.Body != nil && req.Header.Get("Content-Type") == "application/x-www-form-urlencoded" {
+		body, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error reading request body to dump it: %w", err)
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			fmt.Fprintf(&buf, "%s<unparseable form body: %v>%s\n", colorBody, err, colorReset)
+		} else {
+			for k, vs := range form {
+				fmt.Fprintf(&buf, "%sform[%s] = %v%s\n", colorBody, k, vs, colorReset)
+			}
+		}
+	}
+
+	log.Print(buf.String())
+	return nil, nil
+}
+
+func (DumpInterceptor) AfterResponse(req *http.Request, resp *http.Response) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s<<< %s %s%s\n", colorMethod, resp.Status, req.URL, colorReset)
+	writeHeader(&buf, resp.Header)
+
+	if resp.Body != nil && resp.Body != http.NoBody && isTextualContentType(resp.Header.Get("Content-Type")) {
+		// Peek at the body through a bounded bufio.Reader rather than
+		// io.ReadAll-ing it: that lets us print a preview without ever
+		// buffering more than maxDumpBodyPreview bytes, no matter how
+		// large the response actually is, and without truncating what
+		// the client ultimately receives.
+		br := bufio.NewReaderSize(resp.Body, maxDumpBodyPreview)
+		preview, _ := br.Peek(maxDumpBodyPreview)
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{br, resp.Body}
+
+		fmt.Fprintf(&buf, "%s%s", colorBody, preview)
+		if len(preview) == maxDumpBodyPreview {
+			fmt.Fprint(&buf, "...<truncated>")
+		}
+		fmt.Fprintf(&buf, "%s\n", colorReset)
+	}
+
+	log.Print(buf.String())
+	return nil
+}
+
+// isTextualContentType reports whether contentType looks like something
+// safe and useful to print to a terminal, as opposed to e.g. a binary .deb
+// package.
+func isTextualContentType(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.TrimSpace(mediaType)
+	return strings.HasPrefix(mediaType, "text/") ||
+		mediaType == "application/json" ||
+		mediaType == "application/xml" ||
+		mediaType == "application/x-www-form-urlencoded"
+}
+
+func writeHeader(buf *bytes.Buffer, header http.Header) {
+	for k, vs := range header {
+		for _, v := range vs {
+			fmt.Fprintf(buf, "%s%s: %s%s\n", colorHeader, k, v, colorReset)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package interceptor
+
+import (
+	"apt_cacher_go/config"
+	"net/http"
+)
+
+// HeaderRewriter applies a fixed set of header rewrite rules, taken from
+// config, to every request and/or response that passes through the proxy.
+type HeaderRewriter struct {
+	RequestRules  []config.HeaderRewriteRule
+	ResponseRules []config.HeaderRewriteRule
+}
+
+func applyHeaderRewrites(header http.Header, rules []config.HeaderRewriteRule) {
+	for _, rule := range rules {
+		if rule.Value == "" {
+			header.Del(rule.Header)
+			continue
+		}
+		header.Set(rule.Header, rule.Value)
+	}
+}
+
+func (h *HeaderRewriter) BeforeRequest(req *http.Request) (*http.Response, error) {
+	applyHeaderRewrites(req.Header, h.RequestRules)
+	return nil, nil
+}
+
+func (h *HeaderRewriter) AfterResponse(req *http.Request, resp *http.Response) error {
+	applyHeaderRewrites(resp.Header, h.ResponseRules)
+	return nil
+}
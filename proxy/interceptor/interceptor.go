@@ -0,0 +1,22 @@
+// Package interceptor defines the proxy's request/response middleware chain
+// and ships a few built-in interceptors for common debugging and policy
+// tasks.
+package interceptor
+
+import "net/http"
+
+// Interceptor is a hook into the proxy's request/response pipeline. It sees
+// every request before it is sent upstream, and every response once
+// upstream has replied, giving callers a place to inspect, modify, log or
+// reject traffic without touching the core proxy handler.
+type Interceptor interface {
+	// BeforeRequest is called with the request about to be sent upstream.
+	// Returning a non-nil response short-circuits the request: it is sent
+	// to the client as-is, without going upstream or touching the cache,
+	// and no further interceptors are consulted.
+	BeforeRequest(req *http.Request) (*http.Response, error)
+
+	// AfterResponse is called with the response received from upstream. It
+	// may modify resp in place, e.g. to rewrite headers.
+	AfterResponse(req *http.Request, resp *http.Response) error
+}
@@ -0,0 +1,80 @@
+package interceptor
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// URLFilter rejects requests whose URL doesn't match an allow-list, or does
+// match a deny-list, of regular expressions.
+type URLFilter struct {
+	allow []*regexp.Regexp
+	deny  []*regexp.Regexp
+}
+
+// NewURLFilter compiles allow and deny into a URLFilter. An empty allow
+// list means every URL is allowed unless it matches deny.
+func NewURLFilter(allow, deny []string) (*URLFilter, error) {
+	f := &URLFilter{}
+
+	for _, pattern := range allow {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid allow pattern %q: %w", pattern, err)
+		}
+		f.allow = append(f.allow, re)
+	}
+
+	for _, pattern := range deny {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid deny pattern %q: %w", pattern, err)
+		}
+		f.deny = append(f.deny, re)
+	}
+
+	return f, nil
+}
+
+func (f *URLFilter) BeforeRequest(req *http.Request) (*http.Response, error) {
+	url := req.URL.String()
+
+	if len(f.allow) > 0 && !matchesAny(f.allow, url) {
+		return reject(req, "URL is not in the allow list"), nil
+	}
+
+	if matchesAny(f.deny, url) {
+		return reject(req, "URL matches the deny list"), nil
+	}
+
+	return nil, nil
+}
+
+func (f *URLFilter) AfterResponse(req *http.Request, resp *http.Response) error {
+	return nil
+}
+
+func matchesAny(patterns []*regexp.Regexp, s string) bool {
+	for _, re := range patterns {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+func reject(req *http.Request, reason string) *http.Response {
+	return &http.Response{
+		StatusCode: http.StatusForbidden,
+		Status:     http.StatusText(http.StatusForbidden),
+		Proto:      req.Proto,
+		ProtoMajor: req.ProtoMajor,
+		ProtoMinor: req.ProtoMinor,
+		Header:     http.Header{"Content-Type": []string{"text/plain; charset=utf-8"}},
+		Body:       io.NopCloser(strings.NewReader(fmt.Sprintf("blocked by proxy: %s (%s)\n", reason, req.URL))),
+		Request:    req,
+	}
+}
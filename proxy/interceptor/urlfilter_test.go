@@ -0,0 +1,51 @@
+package interceptor
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestURLFilterBeforeRequest(t *testing.T) {
+	f, err := NewURLFilter([]string{`^http://example\.com/`}, []string{`/secret`})
+	if err != nil {
+		t.Fatalf("NewURLFilter: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		url        string
+		wantReject bool
+	}{
+		{name: "allowed", url: "http://example.com/package.deb", wantReject: false},
+		{name: "not in allow list", url: "http://other.com/package.deb", wantReject: true},
+		{name: "matches deny list", url: "http://example.com/secret/package.deb", wantReject: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			resp, err := f.BeforeRequest(req)
+			if err != nil {
+				t.Fatalf("BeforeRequest: %v", err)
+			}
+
+			if tt.wantReject {
+				if resp == nil {
+					t.Fatal("expected a rejection response, got nil")
+				}
+				if resp.StatusCode != http.StatusForbidden {
+					t.Errorf("rejection status = %d, want %d", resp.StatusCode, http.StatusForbidden)
+				}
+			} else if resp != nil {
+				t.Errorf("expected request to pass through, got response %+v", resp)
+			}
+		})
+	}
+}
+
+func TestNewURLFilterInvalidPattern(t *testing.T) {
+	if _, err := NewURLFilter([]string{"("}, nil); err == nil {
+		t.Error("expected an error for an invalid allow pattern")
+	}
+}
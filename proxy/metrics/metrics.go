@@ -0,0 +1,107 @@
+// Package metrics tracks simple operational counters for the proxy and
+// exposes them in the Prometheus text exposition format.
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Metrics tracks request totals, cache hit/miss counts, bytes served, and
+// per-host upstream request latency.
+type Metrics struct {
+	requests    int64
+	cacheHits   int64
+	cacheMisses int64
+	bytesServed int64
+
+	mu      sync.Mutex
+	latency map[string]*hostLatency
+}
+
+type hostLatency struct {
+	count  int64
+	errors int64
+	total  time.Duration
+}
+
+// New creates an empty Metrics.
+func New() *Metrics {
+	return &Metrics{latency: make(map[string]*hostLatency)}
+}
+
+// IncRequests counts one incoming proxy request.
+func (m *Metrics) IncRequests() { atomic.AddInt64(&m.requests, 1) }
+
+// IncCacheHit counts one request served directly from the cache.
+func (m *Metrics) IncCacheHit() { atomic.AddInt64(&m.cacheHits, 1) }
+
+// IncCacheMiss counts one request that required an upstream fetch.
+func (m *Metrics) IncCacheMiss() { atomic.AddInt64(&m.cacheMisses, 1) }
+
+// AddBytesServed adds n to the count of response bytes served to clients.
+func (m *Metrics) AddBytesServed(n int64) { atomic.AddInt64(&m.bytesServed, n) }
+
+// ObserveUpstreamLatency records how long a round trip to host took, and
+// whether it failed, so failed round trips still show up in the request
+// count without dragging down a "successful requests only" latency figure.
+func (m *Metrics) ObserveUpstreamLatency(host string, elapsed time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	hl, ok := m.latency[host]
+	if !ok {
+		hl = &hostLatency{}
+		m.latency[host] = hl
+	}
+	hl.count++
+	if err != nil {
+		hl.errors++
+		return
+	}
+	hl.total += elapsed
+}
+
+// Handler returns an http.Handler serving these metrics in the Prometheus
+// text exposition format, suitable for mounting at an admin endpoint such as
+// "/metrics".
+func (m *Metrics) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Write([]byte(m.render()))
+	})
+}
+
+func (m *Metrics) render() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "# TYPE apt_cacher_requests_total counter\napt_cacher_requests_total %d\n", atomic.LoadInt64(&m.requests))
+	fmt.Fprintf(&b, "# TYPE apt_cacher_cache_hits_total counter\napt_cacher_cache_hits_total %d\n", atomic.LoadInt64(&m.cacheHits))
+	fmt.Fprintf(&b, "# TYPE apt_cacher_cache_misses_total counter\napt_cacher_cache_misses_total %d\n", atomic.LoadInt64(&m.cacheMisses))
+	fmt.Fprintf(&b, "# TYPE apt_cacher_bytes_served_total counter\napt_cacher_bytes_served_total %d\n", atomic.LoadInt64(&m.bytesServed))
+
+	m.mu.Lock()
+	hosts := make([]string, 0, len(m.latency))
+	for host := range m.latency {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	fmt.Fprint(&b, "# TYPE apt_cacher_upstream_requests_total counter\n")
+	fmt.Fprint(&b, "# TYPE apt_cacher_upstream_errors_total counter\n")
+	fmt.Fprint(&b, "# TYPE apt_cacher_upstream_latency_seconds_total counter\n")
+	for _, host := range hosts {
+		hl := m.latency[host]
+		fmt.Fprintf(&b, "apt_cacher_upstream_requests_total{host=%q} %d\n", host, hl.count)
+		fmt.Fprintf(&b, "apt_cacher_upstream_errors_total{host=%q} %d\n", host, hl.errors)
+		fmt.Fprintf(&b, "apt_cacher_upstream_latency_seconds_total{host=%q} %f\n", host, hl.total.Seconds())
+	}
+	m.mu.Unlock()
+
+	return b.String()
+}
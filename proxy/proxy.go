@@ -4,8 +4,12 @@ import (
 	"apt_cacher_go/cache"
 	"apt_cacher_go/config"
 	"apt_cacher_go/proxy/certs"
+	"apt_cacher_go/proxy/interceptor"
+	"apt_cacher_go/proxy/metrics"
 	"apt_cacher_go/proxy/responder"
+	"apt_cacher_go/proxy/upstream"
 	"bufio"
+	"bytes"
 	"crypto/tls"
 	"errors"
 	"fmt"
@@ -13,30 +17,147 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"strconv"
 	"time"
+
+	"golang.org/x/sync/singleflight"
 )
 
 type cachedRequestInfo struct {
-	ETag         string
-	LastModified time.Time
-	Header       http.Header
+	ETag          string
+	LastModified  time.Time
+	Header        http.Header
+	ContentLength int64
+
+	// Ranges tracks how much of the body, starting from byte 0, has
+	// actually been committed to the cache: the full [0, ContentLength)
+	// span for a completed fetch, a shorter prefix if the upstream
+	// connection dropped partway through, or empty for a brand new entry.
+	// See completePartialEntry for how a short prefix gets resumed.
+	Ranges rangeSet
 }
 
 type CachingMitmProxy struct {
 	ca            certs.CertAuthority
 	cache         cache.Cache[cachedRequestInfo]
 	defaultMaxAge time.Duration
+
+	// fetchGroup coalesces concurrent cache misses for the same key so that
+	// only one goroutine fetches from upstream and populates the cache;
+	// the rest wait for it to finish and then serve the fresh entry.
+	fetchGroup singleflight.Group
+
+	// interceptors run, in order, before every upstream fetch and after
+	// every upstream response. See Use.
+	interceptors []interceptor.Interceptor
+
+	// upstream pools per-host transports so repeated requests reuse
+	// keepalive connections instead of re-handshaking every time.
+	upstream *upstream.Pool
+
+	// metrics tracks request/cache/upstream counters, exposed via
+	// MetricsHandler.
+	metrics *metrics.Metrics
+}
+
+// Use registers an interceptor on the proxy's request/response pipeline. It
+// is not safe to call concurrently with requests being served.
+func (p *CachingMitmProxy) Use(i interceptor.Interceptor) {
+	p.interceptors = append(p.interceptors, i)
+}
+
+// fetchError wraps an error encountered while fetching from upstream or
+// writing to the cache, carrying the HTTP status it should be reported with.
+type fetchError struct {
+	status int
+	err    error
+}
+
+func (e *fetchError) Error() string { return e.err.Error() }
+func (e *fetchError) Unwrap() error  { return e.err }
+
+// fetchResult is the outcome of fetching a request from upstream, shared
+// between every caller coalesced onto the same singleflight key.
+type fetchResult struct {
+	statusCode  int
+	header      http.Header
+	notModified bool
+	cached      bool
+	body        []byte
+
+	// servedTo is set to the responder that fetchUpstream already streamed
+	// the response to directly, while it was being written to the cache.
+	// Every caller coalesced onto the same fetchGroup call gets the same
+	// fetchResult back, but only the one whose responder matches servedTo
+	// has actually received a response; the rest still need to serve it
+	// themselves, from the now-committed cache entry.
+	servedTo responder.Responder
 }
 
 // createMitmProxy creates a new MITM proxy. It should be passed the filenames
 // for the certificate and private key of a certificate authority trusted by the
 // client's machine.
 func NewCachingMitmProxy(cacheDir string, ca certs.CertAuthority) (*CachingMitmProxy, error) {
-	return &CachingMitmProxy{
+	c, err := cache.New[cachedRequestInfo](&config.Global.Cache, cacheDir)
+	if err != nil {
+		return nil, fmt.Errorf("error creating cache backend: %w", err)
+	}
+
+	m := metrics.New()
+
+	upstreamCfg := upstream.DefaultConfig()
+	if config.Global.Upstream.MaxIdleConnsPerHost > 0 {
+		upstreamCfg.MaxIdleConnsPerHost = config.Global.Upstream.MaxIdleConnsPerHost
+	}
+	if config.Global.Upstream.IdleConnTimeout > 0 {
+		upstreamCfg.IdleConnTimeout = config.Global.Upstream.IdleConnTimeout
+	}
+	if config.Global.Upstream.ResponseHeaderTimeout > 0 {
+		upstreamCfg.ResponseHeaderTimeout = config.Global.Upstream.ResponseHeaderTimeout
+	}
+	if config.Global.Upstream.DialTimeout > 0 {
+		upstreamCfg.DialTimeout = config.Global.Upstream.DialTimeout
+	}
+	upstreamCfg.ProxyURL = config.Global.Upstream.ProxyURL
+
+	pool := upstream.NewPool(upstreamCfg)
+	pool.OnRequest = m.ObserveUpstreamLatency
+
+	p := &CachingMitmProxy{
 		ca:            ca,
-		cache:         cache.NewFileCache[cachedRequestInfo](cacheDir),
+		cache:         c,
 		defaultMaxAge: 1 * time.Hour, // Default expiration time for cached responses
-	}, nil
+		upstream:      pool,
+		metrics:       m,
+	}
+
+	if config.Global.Debug {
+		p.Use(interceptor.DumpInterceptor{})
+	}
+
+	if len(config.Global.RequestHeaderRewrites) > 0 || len(config.Global.ResponseHeaderRewrites) > 0 {
+		p.Use(&interceptor.HeaderRewriter{
+			RequestRules:  config.Global.RequestHeaderRewrites,
+			ResponseRules: config.Global.ResponseHeaderRewrites,
+		})
+	}
+
+	if len(config.Global.URLFilter.Allow) > 0 || len(config.Global.URLFilter.Deny) > 0 {
+		filter, err := interceptor.NewURLFilter(config.Global.URLFilter.Allow, config.Global.URLFilter.Deny)
+		if err != nil {
+			return nil, fmt.Errorf("error building URL filter: %w", err)
+		}
+		p.Use(filter)
+	}
+
+	return p, nil
+}
+
+// MetricsHandler returns an http.Handler exposing Prometheus-style counters
+// (requests, cache hits/misses, bytes served, upstream latency) for
+// operators to mount on an admin endpoint such as "/metrics".
+func (p *CachingMitmProxy) MetricsHandler() http.Handler {
+	return p.metrics.Handler()
 }
 
 func (p *CachingMitmProxy) ServeHTTP(w http.ResponseWriter, proxyReq *http.Request) {
@@ -53,12 +174,12 @@ func (p *CachingMitmProxy) ServeHTTP(w http.ResponseWriter, proxyReq *http.Reque
 	}
 }
 
-func (p *CachingMitmProxy) getCached(key *cache.CacheKey, req *http.Request) (*cache.Entry[cachedRequestInfo], error) {
+func (p *CachingMitmProxy) getCached(key cache.CacheKey, req *http.Request) (*cache.Entry[cachedRequestInfo], error) {
 	cached, err := p.cache.Get(key)
-	if errors.Is(err, cache.ErrorCacheMiss) {
+	if errors.Is(err, cache.ErrCacheMiss) || errors.Is(err, cache.ErrCacheEntryNotFound) {
 		log.Printf("Cache miss for key %v", key)
 		return nil, nil // Cache miss, return nil to indicate no cached entry
-	} else if cached == nil && !errors.Is(err, cache.ErrorCacheMiss) {
+	} else if err != nil {
 		return nil, fmt.Errorf("error retrieving from cache for key %v: %w", key, err)
 	}
 
@@ -79,6 +200,31 @@ func (p *CachingMitmProxy) getCached(key *cache.CacheKey, req *http.Request) (*c
 	return cached, nil
 }
 
+// entryIsUsable reports whether entry is fresh and fully covers its own
+// ContentLength, so it can be served directly instead of fetching a new
+// copy from upstream. ContentLength <= 0 means upstream never reported a
+// size (e.g. a chunked response), in which case there's nothing to check
+// Ranges against and entry is trusted to be complete.
+func entryIsUsable(entry *cache.Entry[cachedRequestInfo], rangeHeader string) bool {
+	if entry.Stale {
+		return false
+	}
+	if cl := entry.Metadata.Object.ContentLength; cl > 0 && !entry.Metadata.Object.Ranges.full(cl) {
+		return false
+	}
+	return true
+}
+
+// entryIsResumable reports whether entry was left partway through a
+// sequential download (some bytes cached from the start, but not the whole
+// ContentLength) and so can be completed by fetching just the missing tail
+// instead of re-fetching the whole object. See
+// CachingMitmProxy.completePartialEntry.
+func entryIsResumable(entry *cache.Entry[cachedRequestInfo]) bool {
+	o := entry.Metadata.Object
+	return o.ContentLength > 0 && o.Ranges.coveredPrefix() > 0 && !o.Ranges.full(o.ContentLength)
+}
+
 func shouldResponseBeCached(resp *http.Response, upstreamDirective *cacheDirective) bool {
 	if config.Global.AlwaysCache {
 		return true
@@ -89,20 +235,56 @@ func shouldResponseBeCached(resp *http.Response, upstreamDirective *cacheDirecti
 			resp.Request.Method == http.MethodHead)
 }
 
-func sendResponse(r responder.Responder, resp io.Reader, header http.Header, req *http.Request) {
+func (p *CachingMitmProxy) sendResponse(r responder.Responder, statusCode int, resp io.Reader, header http.Header, req *http.Request) {
 	body := resp
 	if req.Method == http.MethodHead {
 		body = http.NoBody
+	} else {
+		body = p.countingReader(body)
 	}
 
 	r.SetHeader(header)
-	if err := r.Write(http.StatusOK, body); err != nil {
+	if err := r.Write(statusCode, body); err != nil {
 		log.Printf("error writing response for '%v': %v", req.URL, err)
 	}
 }
 
+// countingReader wraps r so every byte read through it is added to
+// p.metrics' served-bytes counter.
+func (p *CachingMitmProxy) countingReader(r io.Reader) io.Reader {
+	return &byteCountingReader{r: r, metrics: p.metrics}
+}
+
+type byteCountingReader struct {
+	r       io.Reader
+	metrics *metrics.Metrics
+}
+
+func (c *byteCountingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if n > 0 {
+		c.metrics.AddBytesServed(int64(n))
+	}
+	return n, err
+}
+
+// countingReader wraps r and tracks how many bytes have been read from it
+// so far in n, so a caller downstream of a cache write can tell how much of
+// it actually happened even if the write is reported as failed.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
 func (p *CachingMitmProxy) processHTTPRequest(r responder.Responder, req *http.Request) error {
 	log.Printf("Processing HTTP request %s -> %s %s", req.RemoteAddr, req.Method, req.URL)
+	p.metrics.IncRequests()
 
 	clientDirective := parseCacheDirective(req.Header)
 
@@ -114,6 +296,13 @@ func (p *CachingMitmProxy) processHTTPRequest(r responder.Responder, req *http.R
 	// Otherwise we end up sending headers and getting responses that we don't know how to handle.
 	removeUnsupportedHeaders(req.Header)
 
+	// We always fetch and cache the complete object; range requests are
+	// satisfied afterwards by slicing it, so the same cached bytes serve
+	// both regular and ranged clients. Strip it before anything looks at
+	// req.Header again.
+	rangeHeader := req.Header.Get("Range")
+	req.Header.Del("Range")
+
 	key := cache.MakeFromRequest(req)
 
 	cached, err := p.getCached(key, req)
@@ -125,41 +314,320 @@ func (p *CachingMitmProxy) processHTTPRequest(r responder.Responder, req *http.R
 
 	if cached != nil {
 		defer cached.Data.Close() // Ensure we close the cached data when done
-		if !cached.Stale {
+		if entryIsUsable(cached, rangeHeader) {
+			p.metrics.IncCacheHit()
+			if rangeHeader != "" {
+				log.Printf("Serving range %q for '%v' with key '%v' from cache", rangeHeader, req.URL, key)
+				return p.serveRange(r, req, http.StatusOK, rangeHeader, cached.Metadata.Object.ContentLength, cached.Metadata.Object.Header, cached.Data)
+			}
 			log.Printf("Serving cached response for '%v' with key '%v'", req.URL, key)
-			sendResponse(r, cached.Data, cached.Metadata.Object.Header, req)
+			p.sendResponse(r, http.StatusOK, cached.Data, cached.Metadata.Object.Header, req)
 			return nil
 		}
+		// Either the cache is stale, or it's a partial entry that can be
+		// resumed: either way, fall through to the singleflight-coalesced
+		// fetch below, which also handles resuming (see its closure).
 	}
 
-	log.Printf("No cached response found. Sending request to upstream '%v'", req.URL)
-	resp, err := sendRequestToTarget(req)
+	// Coalesce concurrent misses for the same key: only one goroutine fetches
+	// from upstream (or resumes a partial entry) and populates the cache,
+	// the rest wait here for it. This only applies to GET/HEAD, since those
+	// are the only methods for which concurrent requests are guaranteed to
+	// want the same response.
+	// A range request can't be satisfied by streaming the full upstream
+	// response straight through (the client expects a 206 with just its
+	// slice), so skip the live-stream fast path for it: fetchUpstream will
+	// still fetch and cache the complete object, we just read it back from
+	// the cache afterwards to slice it.
+	liveStreamTo := r
+	if rangeHeader != "" {
+		liveStreamTo = nil
+	}
+
+	var v any
+	var shared bool
+	if req.Method == http.MethodGet || req.Method == http.MethodHead {
+		v, err, shared = p.fetchGroup.Do(fmt.Sprintf("%v", key), func() (any, error) {
+			// singleflight only coalesces calls that are concurrently
+			// in-flight: if the leader's fetch already finished by the
+			// time we reach Do, we're not coalesced onto anything and
+			// would otherwise issue our own redundant upstream fetch. Re-
+			// check the cache before doing that; a fast leader fetch
+			// (e.g. a warm pooled upstream connection) may well have
+			// already landed the entry.
+			if fresh, ferr := p.getCached(key, req); ferr == nil && fresh != nil {
+				if entryIsUsable(fresh, rangeHeader) {
+					header := fresh.Metadata.Object.Header
+					fresh.Data.Close()
+					return &fetchResult{statusCode: http.StatusOK, header: header, cached: true}, nil
+				}
+
+				// Also resume a partial entry here, inside the
+				// singleflight call: this keeps the actual resume fetch
+				// (and the write it does to the cache) coalesced the same
+				// way a normal miss is, so two concurrent requests for the
+				// same partial entry can't race each other to resume it
+				// (which, on the Redis backend, would interleave two
+				// upstream fetches into the same body key).
+				if !fresh.Stale && entryIsResumable(fresh) {
+					log.Printf("Resuming partial download for '%v' with key '%v' (%d/%d bytes cached)", req.URL, key, fresh.Metadata.Object.Ranges.coveredPrefix(), fresh.Metadata.Object.ContentLength)
+					resumed, rerr := p.completePartialEntry(req, key, fresh)
+					fresh.Data.Close()
+					if rerr == nil {
+						header := resumed.Metadata.Object.Header
+						resumed.Data.Close()
+						return &fetchResult{statusCode: http.StatusOK, header: header, cached: true}, nil
+					}
+					log.Printf("error resuming partial download for '%v' with key '%v', falling back to a full re-fetch: %v", req.URL, key, rerr)
+				} else {
+					fresh.Data.Close()
+				}
+			}
+			return p.fetchUpstream(liveStreamTo, req, key, cached)
+		})
+	} else {
+		v, err = p.fetchUpstream(liveStreamTo, req, key, cached)
+	}
+	if err != nil {
+		status := http.StatusBadGateway
+		var fe *fetchError
+		if errors.As(err, &fe) {
+			status = fe.status
+		}
+		r.Error(err, status)
+		return err
+	}
+	if shared {
+		log.Printf("Coalesced concurrent cache miss for '%v' with key '%v'", req.URL, key)
+	}
+	result := v.(*fetchResult)
+
+	if result.notModified {
+		log.Printf("Origin server returned 304 Not Modified, serving cached response for '%v' with key '%v'", req.URL, key)
+		if rangeHeader != "" {
+			return p.serveRange(r, req, http.StatusOK, rangeHeader, cached.Metadata.Object.ContentLength, cached.Metadata.Object.Header, cached.Data)
+		}
+		p.sendResponse(r, http.StatusOK, cached.Data, cached.Metadata.Object.Header, req)
+		return nil
+	}
+
+	if result.servedTo == r {
+		// fetchUpstream already streamed the response straight to this
+		// responder as it was being written to the cache.
+		return nil
+	}
+
+	if result.cached {
+		// Either this call was coalesced onto another goroutine's fetch
+		// (which streamed the response to its own client rather than
+		// ours), or the client asked for a range and fetchUpstream was
+		// told not to stream live so we could slice the complete body
+		// ourselves. Either way, serve from the now-committed cache entry.
+		entry, err := p.cache.Get(key)
+		if err != nil {
+			err = fmt.Errorf("error reading cached entry for '%v' with key '%v' after fetch: %w", req.URL, key, err)
+			r.Error(err, http.StatusInternalServerError)
+			return err
+		}
+		defer entry.Data.Close()
+
+		if rangeHeader != "" {
+			return p.serveRange(r, req, result.statusCode, rangeHeader, entry.Metadata.Object.ContentLength, result.header, entry.Data)
+		}
+
+		log.Printf("Sending response for '%v' with status %d", req.URL, result.statusCode)
+		p.sendResponse(r, result.statusCode, entry.Data, result.header, req)
+		return nil
+	}
+
+	if rangeHeader != "" && result.statusCode == http.StatusOK {
+		return p.serveRange(r, req, result.statusCode, rangeHeader, int64(len(result.body)), result.header, bytes.NewReader(result.body))
+	}
+
+	log.Printf("Sending response for '%v' with status %d", req.URL, result.statusCode)
+	p.sendResponse(r, result.statusCode, bytes.NewReader(result.body), result.header, req)
+	return nil
+}
+
+// serveRange slices data (the complete response body, of the given size and
+// with the given headers) down to the range requested by rangeHeader and
+// writes it as a 206 Partial Content response. If rangeHeader can't be
+// satisfied, it logs why and falls back to serving the complete body.
+func (p *CachingMitmProxy) serveRange(r responder.Responder, req *http.Request, statusCode int, rangeHeader string, size int64, header http.Header, data io.Reader) error {
+	br, err := parseRangeHeader(rangeHeader, size)
+	if err != nil {
+		log.Printf("invalid range %q for '%v', serving full response instead: %v", rangeHeader, req.URL, err)
+		p.sendResponse(r, statusCode, data, header, req)
+		return nil
+	}
+
+	body, err := sliceReader(data, br)
 	if err != nil {
-		log.Printf("error sending request to target (%v): %v", req.URL, err)
-		r.Error(err, http.StatusBadGateway)
+		err = fmt.Errorf("error slicing response for '%v' to range %q: %w", req.URL, rangeHeader, err)
+		r.Error(err, http.StatusInternalServerError)
 		return err
 	}
+
+	out := header.Clone()
+	out.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", br.Start, br.End, size))
+	out.Set("Content-Length", strconv.FormatInt(br.length(), 10))
+	out.Set("Accept-Ranges", "bytes")
+
+	log.Printf("Serving range %q for '%v' with status %d", rangeHeader, req.URL, http.StatusPartialContent)
+	r.SetHeader(out)
+	if err := r.Write(http.StatusPartialContent, p.countingReader(body)); err != nil {
+		log.Printf("error writing range response for '%v': %v", req.URL, err)
+	}
+	return nil
+}
+
+// fetchResultFromResponse turns a response short-circuited by an
+// interceptor's BeforeRequest into a fetchResult, without involving upstream
+// or the cache at all.
+func fetchResultFromResponse(resp *http.Response) (*fetchResult, error) {
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &fetchError{status: http.StatusInternalServerError, err: fmt.Errorf("error reading interceptor response: %w", err)}
+	}
+
+	return &fetchResult{statusCode: resp.StatusCode, header: resp.Header, body: body}, nil
+}
+
+// completePartialEntry resumes a cache entry that was only partially
+// written, most likely because a previous fetch's upstream connection
+// dropped partway through the body (see the Ranges correction in
+// fetchUpstream): it fetches just the missing tail from upstream with a
+// Range request and rewrites the entry as the concatenation of what was
+// already cached and the newly fetched bytes, so the proxy doesn't
+// re-download bytes it already has. If upstream ignores the Range request
+// and answers 200, the whole object is downloaded again and cached as-is.
+//
+// Callers are expected to invoke this from inside the same fetchGroup
+// closure a normal miss uses for this key, so that concurrent requests for
+// the same partial entry coalesce onto one resume instead of racing each
+// other to write it.
+func (p *CachingMitmProxy) completePartialEntry(req *http.Request, key cache.CacheKey, cached *cache.Entry[cachedRequestInfo]) (*cache.Entry[cachedRequestInfo], error) {
+	have := cached.Metadata.Object.Ranges.coveredPrefix()
+
+	resumeReq := req.Clone(req.Context())
+	resumeReq.Header.Set("Range", fmt.Sprintf("bytes=%d-", have))
+
+	for _, ic := range p.interceptors {
+		shortCircuit, err := ic.BeforeRequest(resumeReq)
+		if err != nil {
+			return nil, fmt.Errorf("interceptor rejected resume request for '%v': %w", req.URL, err)
+		}
+		if shortCircuit != nil {
+			shortCircuit.Body.Close()
+			return nil, fmt.Errorf("interceptor short-circuited resume request for '%v'", req.URL)
+		}
+	}
+
+	resp, err := p.upstream.Do(resumeReq)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching remainder of '%v' from upstream: %w", req.URL, err)
+	}
+	defer resp.Body.Close()
+
+	for _, ic := range p.interceptors {
+		if err := ic.AfterResponse(resumeReq, resp); err != nil {
+			log.Printf("interceptor error handling resume response for '%v': %v", req.URL, err)
+		}
+	}
+
+	var body io.Reader = resp.Body
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		// The expected case: upstream honored the Range request, so
+		// prepend what we already have onto its response.
+		body = io.MultiReader(cached.Data, resp.Body)
+	case http.StatusOK:
+		// Upstream doesn't support Range and sent the whole object again
+		// from byte 0; use it as-is instead of prepending a second copy
+		// of what we already have.
+	default:
+		return nil, fmt.Errorf("unexpected status %s resuming '%v' from byte %d", resp.Status, req.URL, have)
+	}
+
+	counter := &countingReader{r: body}
+
+	// Reuse the headers, ETag, etc. already recorded for this entry: they
+	// describe the resource as a whole, not just the tail fetched here.
+	stream, err := p.cache.CacheStream(key, counter, cached.Metadata.Expires, cached.Metadata.Object)
+	if err != nil {
+		return nil, fmt.Errorf("error re-caching '%v' with key '%v': %w", req.URL, key, err)
+	}
+	if _, err := io.Copy(io.Discard, stream); err != nil {
+		log.Printf("error reading response for '%v' while resuming cache entry: %v", req.URL, err)
+	}
+	closeErr := stream.Close()
+
+	// counter wraps body, which for a 206 already starts with the cached
+	// prefix, so it counts the whole rewritten entry in both cases.
+	written := counter.n
+	if uerr := p.cache.UpdateMetadata(key, func(m *cache.EntryMetadata[cachedRequestInfo]) {
+		m.Object.Ranges = prefixRangeSet(written)
+	}); uerr != nil {
+		log.Printf("error recording cached byte range for '%v' with key '%v': %v", req.URL, key, uerr)
+	}
+
+	if closeErr != nil {
+		return nil, fmt.Errorf("error completing cache entry for '%v' with key '%v': %w", req.URL, key, closeErr)
+	}
+
+	return p.cache.Get(key)
+}
+
+// fetchUpstream fetches req from upstream. If the response is cacheable, it
+// is streamed straight to r as it is written to the cache, so r doesn't have
+// to wait for the whole body to arrive before it sees the first byte. It may
+// run on behalf of several callers coalesced via fetchGroup, in which case r
+// is whichever one of them happened to trigger the fetch; the rest learn
+// this via fetchResult.servedTo and serve themselves from the cache once it
+// returns.
+func (p *CachingMitmProxy) fetchUpstream(r responder.Responder, req *http.Request, key cache.CacheKey, cached *cache.Entry[cachedRequestInfo]) (*fetchResult, error) {
+	for _, ic := range p.interceptors {
+		shortCircuit, err := ic.BeforeRequest(req)
+		if err != nil {
+			return nil, &fetchError{status: http.StatusInternalServerError, err: fmt.Errorf("interceptor rejected request for '%v': %w", req.URL, err)}
+		}
+		if shortCircuit != nil {
+			return fetchResultFromResponse(shortCircuit)
+		}
+	}
+
+	log.Printf("No cached response found. Sending request to upstream '%v'", req.URL)
+	p.metrics.IncCacheMiss()
+	resp, err := p.upstream.Do(req)
+	if err != nil {
+		return nil, &fetchError{status: http.StatusBadGateway, err: fmt.Errorf("error sending request to target (%v): %w", req.URL, err)}
+	}
 	defer resp.Body.Close() // Ensure we close the response body when done
 
+	for _, ic := range p.interceptors {
+		if err := ic.AfterResponse(req, resp); err != nil {
+			log.Printf("interceptor error handling response for '%v': %v", req.URL, err)
+		}
+	}
+
 	if resp.StatusCode == http.StatusNotModified {
 		if cached == nil {
 			log.Printf("Received 304 Not Modified but no cached response found for '%v' with key '%v'\nRequest headers might be malformed.\nRequest headers: %v", req.URL, key, req.Header)
-			err := fmt.Errorf("received 304 Not Modified but no cached response found for '%v' with key '%v'", req.URL, key)
-			r.Error(err, http.StatusInternalServerError)
-			return err
+			return nil, &fetchError{
+				status: http.StatusInternalServerError,
+				err:    fmt.Errorf("received 304 Not Modified but no cached response found for '%v' with key '%v'", req.URL, key),
+			}
 		}
 
 		p.cache.UpdateMetadata(key, func(meta *cache.EntryMetadata[cachedRequestInfo]) {
 			// Update the metadata to reflect that the cached response is still valid.
 			meta.Expires = time.Now().Add(p.defaultMaxAge)
 		})
-		log.Printf("Origin server returned 304 Not Modified, serving cached response for '%v' with key '%v'", req.URL, key)
-		sendResponse(r, cached.Data, cached.Metadata.Object.Header, req)
-		return nil
+		return &fetchResult{statusCode: resp.StatusCode, notModified: true}, nil
 	}
 
-	var data io.Reader = resp.Body
-
 	upstreamDirective := parseCacheDirective(resp.Header)
 
 	if shouldResponseBeCached(resp, upstreamDirective) {
@@ -172,24 +640,89 @@ func (p *CachingMitmProxy) processHTTPRequest(r responder.Responder, req *http.R
 
 		etag := resp.Header.Get("ETag")
 
-		entry, err := p.cache.Cache(key, resp.Body, upstreamDirective.getExpiresOrDefault(p.defaultMaxAge), cachedRequestInfo{
-			ETag:         etag,
-			LastModified: lastModified,
-			Header:       resp.Header,
+		// counter tracks how many bytes actually made it into the cache,
+		// independent of whether the copy below runs to completion: if
+		// upstream drops the connection partway through, Ranges is
+		// corrected to that prefix afterwards instead of claiming full
+		// coverage, so a later request can resume from here instead of
+		// re-downloading the whole object (see completePartialEntry).
+		counter := &countingReader{r: resp.Body}
+
+		stream, err := p.cache.CacheStream(key, counter, upstreamDirective.getExpiresOrDefault(p.defaultMaxAge), cachedRequestInfo{
+			ETag:          etag,
+			LastModified:  lastModified,
+			Header:        resp.Header,
+			ContentLength: resp.ContentLength,
+			Ranges:        nil, // corrected below once the copy has run
 		})
 		if err != nil {
-			log.Printf("error caching response for '%v' with key '%v': %v", req.URL, key, err)
-			r.Error(err, http.StatusInternalServerError)
-			return fmt.Errorf("error caching response for '%v' with key '%v': %v", req.URL, key, err)
+			return nil, &fetchError{
+				status: http.StatusInternalServerError,
+				err:    fmt.Errorf("error caching response for '%v' with key '%v': %w", req.URL, key, err),
+			}
+		}
+
+		servedTo := r
+		if r == nil {
+			// The caller wants to slice the cached entry itself afterwards
+			// (e.g. to answer a range request); just drive the stream to
+			// completion so the cache write finishes.
+			if _, err := io.Copy(io.Discard, stream); err != nil {
+				log.Printf("error reading response for '%v' while populating cache: %v", req.URL, err)
+			}
+		} else {
+			// Forward bytes to the client as they're written to the cache,
+			// rather than waiting for the whole response to be cached
+			// first. If the client goes away mid-stream, keep draining
+			// stream so the cache write still completes and later requests
+			// can be served from it.
+			r.SetHeader(resp.Header)
+			body := p.countingReader(stream)
+			if req.Method == http.MethodHead {
+				// Don't ship the body over the wire for HEAD; stream is
+				// still drained below so the cache write completes.
+				body = http.NoBody
+			}
+			writeErr := r.Write(resp.StatusCode, body)
+			if writeErr != nil {
+				log.Printf("error streaming response to client for '%v': %v; continuing to populate cache", req.URL, writeErr)
+			}
+			if req.Method == http.MethodHead || writeErr != nil {
+				io.Copy(io.Discard, stream)
+			}
+		}
+
+		closeErr := stream.Close()
+
+		// HEAD never has a body to stream regardless of Content-Length, so
+		// there's nothing to resume: trust the header instead of the
+		// (always zero) counted bytes.
+		written := counter.n
+		if req.Method == http.MethodHead {
+			written = resp.ContentLength
+		}
+		if uerr := p.cache.UpdateMetadata(key, func(meta *cache.EntryMetadata[cachedRequestInfo]) {
+			meta.Object.Ranges = prefixRangeSet(written)
+		}); uerr != nil {
+			log.Printf("error recording cached byte range for '%v' with key '%v': %v", req.URL, key, uerr)
+		}
+
+		if closeErr != nil {
+			return nil, &fetchError{
+				status: http.StatusInternalServerError,
+				err:    fmt.Errorf("error caching response for '%v' with key '%v': %w", req.URL, key, closeErr),
+			}
 		}
-		defer entry.Data.Close() // Ensure we close the cached data when done
 
-		data = entry.Data
+		return &fetchResult{statusCode: resp.StatusCode, header: resp.Header, cached: true, servedTo: servedTo}, nil
 	}
 
-	log.Printf("Sending response for '%v' with status %d", req.URL, resp.StatusCode)
-	sendResponse(r, data, resp.Header, req)
-	return nil
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &fetchError{status: http.StatusBadGateway, err: fmt.Errorf("error reading response body for '%v': %w", req.URL, err)}
+	}
+
+	return &fetchResult{statusCode: resp.StatusCode, header: resp.Header, body: body}, nil
 }
 
 func (p *CachingMitmProxy) handleHTTP(w http.ResponseWriter, proxyReq *http.Request) error {
@@ -227,12 +760,6 @@ func (p *CachingMitmProxy) handleCONNECT(w http.ResponseWriter, proxyReq *http.R
 		return err
 	}
 
-	tlsCert, err := p.ca.GetCertForHost(proxyReq.Host)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return err
-	}
-
 	// Send an HTTP OK response back to the client; this initiates the CONNECT
 	// tunnel. From this point on the client will assume it's connected directly
 	// to the target.
@@ -241,11 +768,19 @@ func (p *CachingMitmProxy) handleCONNECT(w http.ResponseWriter, proxyReq *http.R
 	}
 	log.Print("Sent HTTP 200 OK response to client, established CONNECT tunnel")
 
-	// Configure a new TLS server, pointing it at the client connection, using
-	// our certificate. This server will now pretend being the target.
+	// Configure a new TLS server, pointing it at the client connection. The
+	// leaf certificate is picked lazily from the client's SNI rather than
+	// the CONNECT target, so virtual hosts sharing one tunnel IP (and
+	// wildcard targets) each get the right certificate.
 	tlsConfig := &tls.Config{
-		MinVersion:   tls.VersionTLS12,
-		Certificates: []tls.Certificate{*tlsCert},
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			host := hello.ServerName
+			if host == "" {
+				host = proxyReq.Host
+			}
+			return p.ca.GetCertForHost(host)
+		},
 	}
 	tlsConn := tls.Server(clientConn, tlsConfig)
 	defer tlsConn.Close()
@@ -0,0 +1,140 @@
+package proxy
+
+import (
+	"apt_cacher_go/proxy/responder"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// TestProcessHTTPRequestCoalescesConcurrentCacheMisses verifies that N
+// concurrent GETs for the same uncached URL result in exactly one upstream
+// request, with every caller still receiving the response.
+func TestProcessHTTPRequestCoalescesConcurrentCacheMisses(t *testing.T) {
+	var upstreamHits int32
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&upstreamHits, 1)
+		w.Header().Set("Cache-Control", "max-age=3600")
+		w.Write([]byte("package contents"))
+	}))
+	defer upstream.Close()
+
+	p, err := NewCachingMitmProxy(t.TempDir(), nil)
+	if err != nil {
+		t.Fatalf("NewCachingMitmProxy: %v", err)
+	}
+
+	const concurrency = 10
+	var wg sync.WaitGroup
+	wg.Add(concurrency)
+	for i := 0; i < concurrency; i++ {
+		go func() {
+			defer wg.Done()
+
+			req := httptest.NewRequest(http.MethodGet, upstream.URL+"/package.deb", nil)
+			rec := httptest.NewRecorder()
+			if err := p.processHTTPRequest(responder.NewHTTPResponder(rec), req); err != nil {
+				t.Errorf("processHTTPRequest: %v", err)
+				return
+			}
+			if rec.Code != http.StatusOK {
+				t.Errorf("unexpected status code: %d", rec.Code)
+			}
+			if got := rec.Body.String(); got != "package contents" {
+				t.Errorf("unexpected body: %q", got)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := atomic.LoadInt32(&upstreamHits); got != 1 {
+		t.Errorf("expected exactly one upstream hit, got %d", got)
+	}
+}
+
+// TestProcessHTTPRequestResumesPartialDownload verifies that when an
+// upstream connection drops partway through a response, the bytes already
+// written to the cache aren't discarded: the next request for the same URL
+// fetches only the missing tail (via a Range request) instead of
+// re-downloading the whole object, and the client ends up with the complete
+// body either way.
+func TestProcessHTTPRequestResumesPartialDownload(t *testing.T) {
+	full := []byte("0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJ")
+	const prefixLen = 10
+
+	var upstreamHits int32
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hit := atomic.AddInt32(&upstreamHits, 1)
+
+		if hit == 1 {
+			// Simulate a connection that drops after sending only a prefix
+			// of the declared Content-Length, by hijacking the connection
+			// and closing it ourselves instead of letting net/http pad or
+			// reject the short write.
+			hj, ok := w.(http.Hijacker)
+			if !ok {
+				t.Fatal("ResponseWriter does not support hijacking")
+			}
+			conn, buf, err := hj.Hijack()
+			if err != nil {
+				t.Fatalf("Hijack: %v", err)
+			}
+			defer conn.Close()
+
+			fmt.Fprintf(buf, "HTTP/1.1 200 OK\r\nContent-Length: %d\r\nCache-Control: max-age=3600\r\n\r\n", len(full))
+			buf.Write(full[:prefixLen])
+			buf.Flush()
+			return
+		}
+
+		wantRange := fmt.Sprintf("bytes=%d-", prefixLen)
+		if got := r.Header.Get("Range"); got != wantRange {
+			t.Errorf("resume request Range = %q, want %q", got, wantRange)
+		}
+
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", prefixLen, len(full)-1, len(full)))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(full[prefixLen:])
+	}))
+	defer upstream.Close()
+
+	p, err := NewCachingMitmProxy(t.TempDir(), nil)
+	if err != nil {
+		t.Fatalf("NewCachingMitmProxy: %v", err)
+	}
+
+	// First request: upstream drops the connection partway through, so only
+	// a prefix ends up committed to the cache.
+	firstReq := httptest.NewRequest(http.MethodGet, upstream.URL+"/package.deb", nil)
+	firstRec := httptest.NewRecorder()
+	p.processHTTPRequest(responder.NewHTTPResponder(firstRec), firstReq)
+
+	// Second request: the proxy should resume from byte prefixLen rather
+	// than re-fetching the whole object, and the client should see the
+	// complete body.
+	secondReq := httptest.NewRequest(http.MethodGet, upstream.URL+"/package.deb", nil)
+	secondRec := httptest.NewRecorder()
+	if err := p.processHTTPRequest(responder.NewHTTPResponder(secondRec), secondReq); err != nil {
+		t.Fatalf("processHTTPRequest: %v", err)
+	}
+
+	if secondRec.Code != http.StatusOK {
+		t.Errorf("unexpected status code: %d", secondRec.Code)
+	}
+	if got := secondRec.Body.String(); got != string(full) {
+		t.Errorf("unexpected body: got %q, want %q", got, string(full))
+	}
+	if got := secondRec.Header().Get("Content-Length"); got != strconv.Itoa(len(full)) {
+		t.Errorf("unexpected Content-Length: %q", got)
+	}
+
+	if got := atomic.LoadInt32(&upstreamHits); got != 2 {
+		t.Errorf("expected exactly two upstream hits (initial + resume), got %d", got)
+	}
+}
@@ -0,0 +1,34 @@
+package responder
+
+import (
+	"io"
+	"net/http"
+)
+
+// httpResponder is the Responder used for plain (non-CONNECT) requests,
+// backed directly by the net/http server's http.ResponseWriter.
+type httpResponder struct {
+	w http.ResponseWriter
+}
+
+// NewHTTPResponder returns a Responder that writes to w.
+func NewHTTPResponder(w http.ResponseWriter) Responder {
+	return &httpResponder{w: w}
+}
+
+func (r *httpResponder) SetHeader(header http.Header) {
+	dst := r.w.Header()
+	for k, v := range header {
+		dst[k] = v
+	}
+}
+
+func (r *httpResponder) Write(statusCode int, body io.Reader) error {
+	r.w.WriteHeader(statusCode)
+	_, err := io.Copy(r.w, body)
+	return err
+}
+
+func (r *httpResponder) Error(err error, statusCode int) {
+	http.Error(r.w, err.Error(), statusCode)
+}
@@ -0,0 +1,69 @@
+package responder
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// rawResponder is the Responder used for requests read off a hijacked
+// CONNECT tunnel, where there is no http.ResponseWriter: it serializes the
+// response itself and writes the wire bytes straight to conn.
+type rawResponder struct {
+	conn   net.Conn
+	header http.Header
+}
+
+// NewRawHTTPResponder returns a Responder that writes HTTP/1.1 responses
+// directly to conn.
+func NewRawHTTPResponder(conn net.Conn) Responder {
+	return &rawResponder{conn: conn, header: make(http.Header)}
+}
+
+func (r *rawResponder) SetHeader(header http.Header) {
+	for k, v := range header {
+		r.header[k] = v
+	}
+}
+
+func (r *rawResponder) Write(statusCode int, body io.Reader) error {
+	resp := &http.Response{
+		StatusCode: statusCode,
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     r.header,
+		Body:       io.NopCloser(body),
+	}
+
+	// resp.ContentLength defaults to 0, which http.Response.Write can't
+	// tell apart from "body is genuinely empty": left alone, it writes the
+	// body with no Content-Length and no chunked encoding, relying on the
+	// connection closing to mark the end. That never happens here, since
+	// handleCONNECT keeps reading further requests off the same tlsConn
+	// afterward, so a client reading the body blocks forever. Use the
+	// Content-Length header already set by the caller (see sendResponse,
+	// serveRange) when there is one; otherwise the length genuinely isn't
+	// known up front, so force chunked encoding instead.
+	resp.ContentLength = -1
+	if cl := r.header.Get("Content-Length"); cl != "" {
+		if n, err := strconv.ParseInt(cl, 10, 64); err == nil {
+			resp.ContentLength = n
+		}
+	}
+	if resp.ContentLength < 0 {
+		resp.TransferEncoding = []string{"chunked"}
+	}
+
+	err := resp.Write(r.conn)
+	// The headers belong to this response only; the next request on the
+	// same tunnel starts from an empty set.
+	r.header = make(http.Header)
+	return err
+}
+
+func (r *rawResponder) Error(err error, statusCode int) {
+	r.header = http.Header{"Content-Type": []string{"text/plain; charset=utf-8"}}
+	r.Write(statusCode, strings.NewReader(err.Error()))
+}
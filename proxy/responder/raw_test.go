@@ -0,0 +1,65 @@
+package responder
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// TestRawResponderWriteFramesBodyCorrectly verifies that a client reading
+// the response off the wire via io.ReadAll(resp.Body) (what every real HTTP
+// client, including apt/curl, does) sees exactly the written body and
+// returns, rather than blocking forever waiting for the connection to
+// close: rawResponder is used on a CONNECT tunnel, which keeps the
+// connection open to read further requests, so the body itself must be
+// correctly framed with either Content-Length or chunked encoding.
+func TestRawResponderWriteFramesBodyCorrectly(t *testing.T) {
+	tests := []struct {
+		name          string
+		contentLength string // "" means no Content-Length header is set
+		body          string
+	}{
+		{name: "known length", contentLength: "13", body: "package bytes"},
+		{name: "unknown length", contentLength: "", body: "package bytes"},
+		{name: "known empty", contentLength: "0", body: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, client := net.Pipe()
+			defer server.Close()
+			defer client.Close()
+
+			r := NewRawHTTPResponder(server)
+			if tt.contentLength != "" {
+				r.SetHeader(http.Header{"Content-Length": []string{tt.contentLength}})
+			}
+
+			writeDone := make(chan error, 1)
+			go func() {
+				writeDone <- r.Write(http.StatusOK, strings.NewReader(tt.body))
+			}()
+
+			resp, err := http.ReadResponse(bufio.NewReader(client), nil)
+			if err != nil {
+				t.Fatalf("ReadResponse: %v", err)
+			}
+			defer resp.Body.Close()
+
+			got, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("reading response body: %v", err)
+			}
+			if string(got) != tt.body {
+				t.Errorf("unexpected body: got %q, want %q", got, tt.body)
+			}
+
+			if err := <-writeDone; err != nil {
+				t.Errorf("Write: %v", err)
+			}
+		})
+	}
+}
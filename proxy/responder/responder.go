@@ -0,0 +1,26 @@
+// Package responder abstracts over the two ways CachingMitmProxy can write
+// an HTTP response back to a client: through the net/http server's
+// http.ResponseWriter for plain HTTP requests, and directly onto a hijacked
+// net.Conn for requests read off a CONNECT tunnel, where there is no
+// http.ResponseWriter to begin with.
+package responder
+
+import (
+	"io"
+	"net/http"
+)
+
+// Responder is the minimal surface CachingMitmProxy needs to send a response
+// to a client, regardless of which of the two cases above it is.
+type Responder interface {
+	// SetHeader merges header into the response headers that will be sent
+	// by the next call to Write.
+	SetHeader(header http.Header)
+
+	// Write sends the response with the given status code and body. body
+	// is read to completion or to the first error.
+	Write(statusCode int, body io.Reader) error
+
+	// Error sends err as a plain-text response with the given status code.
+	Error(err error, statusCode int)
+}
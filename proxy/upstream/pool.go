@@ -0,0 +1,119 @@
+// Package upstream maintains a pool of per-host HTTP transports so that
+// repeated requests to the same upstream reuse keepalive (and, where
+// supported, HTTP/2) connections instead of paying for a fresh TCP+TLS
+// handshake on every fetch.
+package upstream
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Config tunes the transports handed out by a Pool.
+type Config struct {
+	// MaxIdleConnsPerHost bounds how many idle keepalive connections are
+	// kept around per host.
+	MaxIdleConnsPerHost int
+
+	// IdleConnTimeout is how long an idle connection is kept before being
+	// closed.
+	IdleConnTimeout time.Duration
+
+	// ResponseHeaderTimeout bounds how long to wait for the response
+	// headers after the request has been written.
+	ResponseHeaderTimeout time.Duration
+
+	// DialTimeout bounds how long establishing the underlying TCP
+	// connection may take.
+	DialTimeout time.Duration
+
+	// ProxyURL, if set, chains all outgoing requests through this upstream
+	// proxy (HTTP_PROXY-style).
+	ProxyURL string
+}
+
+// DefaultConfig returns reasonable defaults for proxying package downloads.
+func DefaultConfig() Config {
+	return Config{
+		MaxIdleConnsPerHost:   16,
+		IdleConnTimeout:       90 * time.Second,
+		ResponseHeaderTimeout: 30 * time.Second,
+		DialTimeout:           10 * time.Second,
+	}
+}
+
+// Pool maintains one *http.Transport, and therefore one connection pool,
+// per scheme+host.
+type Pool struct {
+	cfg Config
+
+	// OnRequest, if set, is called after every round trip with the
+	// destination host, how long it took, and the error (if any), so a
+	// caller can feed it into its own metrics.
+	OnRequest func(host string, elapsed time.Duration, err error)
+
+	mu         sync.Mutex
+	transports map[string]*http.Transport
+}
+
+// NewPool creates a Pool whose transports are configured from cfg.
+func NewPool(cfg Config) *Pool {
+	return &Pool{
+		cfg:        cfg,
+		transports: make(map[string]*http.Transport),
+	}
+}
+
+func (p *Pool) transportFor(host string) (*http.Transport, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if t, ok := p.transports[host]; ok {
+		return t, nil
+	}
+
+	dialer := &net.Dialer{Timeout: p.cfg.DialTimeout}
+	t := &http.Transport{
+		DialContext:           dialer.DialContext,
+		MaxIdleConnsPerHost:   p.cfg.MaxIdleConnsPerHost,
+		IdleConnTimeout:       p.cfg.IdleConnTimeout,
+		ResponseHeaderTimeout: p.cfg.ResponseHeaderTimeout,
+		TLSClientConfig:       &tls.Config{},
+		ForceAttemptHTTP2:     true,
+	}
+
+	if p.cfg.ProxyURL != "" {
+		proxyURL, err := url.Parse(p.cfg.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid upstream proxy URL %q: %w", p.cfg.ProxyURL, err)
+		}
+		t.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	p.transports[host] = t
+	return t, nil
+}
+
+// Do sends req using the transport for req.URL.Scheme+req.URL.Host,
+// creating that transport on first use, and reports the round trip via
+// OnRequest.
+func (p *Pool) Do(req *http.Request) (*http.Response, error) {
+	host := req.URL.Scheme + "://" + req.URL.Host
+
+	t, err := p.transportFor(host)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	resp, err := t.RoundTrip(req)
+	if p.OnRequest != nil {
+		p.OnRequest(host, time.Since(start), err)
+	}
+	return resp, err
+}